@@ -0,0 +1,91 @@
+//go:build !noavro
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// avroSchema defines Avro schema of default {id, data} records
+const avroSchema = `{"type":"record","name":"Record","namespace":"httpgo","fields":[{"name":"id","type":"long"},{"name":"data","type":"bytes"}]}`
+
+// register avro output format, it can be excluded from the build
+// via noavro build tag
+func init() {
+	formats["avro"] = true
+}
+
+// helper function to check that generated records match avroSchema, i.e.
+// options which change structure of default records are not used
+func avroCompatible(opts genOptions) error {
+	if len(opts.Schema) > 0 {
+		return errors.New("avro format does not support schema, records have fixed {id, data} structure")
+	}
+	if opts.Template != nil {
+		return errors.New("avro format does not support record template, records have fixed {id, data} structure")
+	}
+	if opts.IDField != "" && opts.IDField != "id" {
+		return fmt.Errorf("avro format does not support idfield %q", opts.IDField)
+	}
+	if opts.IDType != "" && opts.IDType != "int" {
+		return fmt.Errorf("avro format does not support idtype %q", opts.IDType)
+	}
+	if opts.Depth > 0 {
+		return errors.New("avro format does not support nested data, depth should be 0")
+	}
+	if opts.Timestamp != "" {
+		return errors.New("avro format does not support timestamp field")
+	}
+	return nil
+}
+
+// helper function to derive sync marker of Avro container from given seed,
+// it keeps output of seeded requests reproducible
+func avroSyncMarker(seed int64) [16]byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(seed))
+	var marker [16]byte
+	sum := sha256.Sum256(buf[:])
+	copy(marker[:], sum[:])
+	return marker
+}
+
+// helper function to stream records produced lazily by given generator as
+// Avro object container file, records are written in blocks of flushRecords
+// records to keep memory usage flat regardless of number of records
+func writeAvro(ctx context.Context, w io.Writer, gen *recordGenerator, marker [16]byte) error {
+	ocf, err := goavro.NewOCFWriter(goavro.OCFConfig{W: w, Schema: avroSchema, SyncMarker: marker})
+	if err != nil {
+		return err
+	}
+	block := make([]interface{}, 0, flushRecords)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rec, ok, err := gen.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			block = append(block, map[string]interface{}{"id": rec["id"], "data": rec["data"]})
+		}
+		if len(block) == flushRecords || (!ok && len(block) > 0) {
+			if err := ocf.Append(block); err != nil {
+				return err
+			}
+			block = block[:0]
+			flush(w)
+		}
+		if !ok {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build noavro
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// avroCompatible is not used in builds with noavro tag since the avro
+// format is not registered and PayloadHandler rejects it
+func avroCompatible(opts genOptions) error {
+	return errors.New("avro format is not supported by this build")
+}
+
+// avroSyncMarker is not used in builds with noavro tag
+func avroSyncMarker(seed int64) [16]byte {
+	return [16]byte{}
+}
+
+// writeAvro is not available in builds with noavro tag
+func writeAvro(ctx context.Context, w io.Writer, gen *recordGenerator, marker [16]byte) error {
+	return errors.New("avro format is not supported by this build")
+}
@@ -0,0 +1,15 @@
+//go:build !nobrotli
+
+package main
+
+import (
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+// register brotli content encoding, it can be excluded from the build
+// via nobrotli build tag
+func init() {
+	compressors["br"] = func(w io.Writer) compressWriter { return brotli.NewWriter(w) }
+}
@@ -0,0 +1,54 @@
+//go:build !nobson
+
+package main
+
+import (
+	"context"
+	"io"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// register bson output format, it can be excluded from the build
+// via nobson build tag
+func init() {
+	formats["bson"] = true
+}
+
+// helper function to convert record into BSON document with sorted keys,
+// nested records are converted as well to keep output reproducible since
+// order of map keys is random, byte slices are encoded as BSON binary
+func bsonDoc(rec map[string]interface{}) bson.D {
+	keys := make([]string, 0, len(rec))
+	for k := range rec {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	doc := make(bson.D, 0, len(keys))
+	for _, k := range keys {
+		val := rec[k]
+		switch v := val.(type) {
+		case Record:
+			val = bsonDoc(v)
+		case map[string]interface{}:
+			val = bsonDoc(v)
+		}
+		doc = append(doc, bson.E{Key: k, Value: val})
+	}
+	return doc
+}
+
+// helper function to stream records produced lazily by given generator as
+// sequence of BSON documents, e.g. as produced by mongodump
+func writeBSON(ctx context.Context, w io.Writer, gen *recordGenerator, delay time.Duration) error {
+	return streamRecords(ctx, w, gen, delay, func(w io.Writer, seq int, rec Record) error {
+		data, err := bson.Marshal(bsonDoc(rec))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+}
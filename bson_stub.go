@@ -0,0 +1,16 @@
+//go:build nobson
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// writeBSON is not available in builds with nobson tag,
+// the bson format is not registered and PayloadHandler rejects it
+func writeBSON(ctx context.Context, w io.Writer, gen *recordGenerator, delay time.Duration) error {
+	return errors.New("bson format is not supported by this build")
+}
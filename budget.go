@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultGenBudgetTimeout defines how long requests wait for generation
+// budget when Config.GenBudgetTimeout is not set
+const defaultGenBudgetTimeout = 10 * time.Second
+
+// genBudget bounds total estimated size of records being generated by all
+// in-flight requests, requests over the budget wait until other requests
+// complete, it applies backpressure by size rather than by number of
+// requests as concurrencyLimiter does, nil budget is unlimited
+type genBudget struct {
+	limit int64
+	used  atomic.Int64
+	mu    sync.Mutex
+	freed chan struct{} // closed and replaced whenever budget is released
+}
+
+// genBudgetLimiter holds generation budget of the server, it is enabled
+// by Config.MaxTotalGenBytes
+var genBudgetLimiter *genBudget
+
+// genBudgetTimeout defines how long requests wait for generation budget
+var genBudgetTimeout = defaultGenBudgetTimeout
+
+// helper function to create generation budget of given size in bytes
+func newGenBudget(limit int64) *genBudget {
+	return &genBudget{limit: limit, freed: make(chan struct{})}
+}
+
+// helper function to return channel which is closed on next release
+func (b *genBudget) released() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.freed
+}
+
+// helper function to acquire given number of bytes of the budget, it blocks
+// until budget is available or given context is done, requests larger than
+// the whole budget are admitted only when no other generation is in flight
+func (b *genBudget) acquire(ctx context.Context, n int64) error {
+	if b == nil {
+		return nil
+	}
+	for {
+		// take release channel before checking usage to not miss the release
+		freed := b.released()
+		used := b.used.Load()
+		if used == 0 || used+n <= b.limit {
+			if b.used.CompareAndSwap(used, used+n) {
+				return nil
+			}
+			continue
+		}
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// helper function to release given number of bytes of the budget and
+// wake up waiting requests
+func (b *genBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+	b.used.Add(-n)
+	b.mu.Lock()
+	close(b.freed)
+	b.freed = make(chan struct{})
+	b.mu.Unlock()
+}
+
+// helper function to estimate size of records to be generated, in size
+// mode it is the target size, otherwise it is based on size of the data
+// field which is base64 encoded in JSON output
+func estimateGenBytes(count int, target int64, opts genOptions) int64 {
+	if count < 0 {
+		return target
+	}
+	size := opts.DataSize
+	if size <= 0 {
+		size = dataSize
+	}
+	// base64 encoding inflates data by 4/3, JSON syntax and id add some bytes
+	perRecord := int64(size)*4/3 + 32
+	if int64(count) > math.MaxInt64/perRecord {
+		return math.MaxInt64
+	}
+	return int64(count) * perRecord
+}
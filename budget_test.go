@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestGenBudget tests that requests over the budget wait for its release
+func TestGenBudget(t *testing.T) {
+	var unlimited *genBudget
+	if err := unlimited.acquire(context.Background(), 1<<40); err != nil {
+		t.Fatalf("nil budget rejected request, error %v", err)
+	}
+	b := newGenBudget(100)
+	if err := b.acquire(context.Background(), 60); err != nil {
+		t.Fatalf("unable to acquire budget, error %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.acquire(ctx, 60); err != context.DeadlineExceeded {
+		t.Fatalf("request over the budget returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+	done := make(chan error)
+	go func() { done <- b.acquire(context.Background(), 60) }()
+	select {
+	case err := <-done:
+		t.Fatalf("request over the budget is admitted, error %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	b.release(60)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unable to acquire released budget, error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting request is not admitted after release")
+	}
+	b.release(60)
+	// request larger than the whole budget is admitted when budget is idle
+	if err := b.acquire(context.Background(), 1000); err != nil {
+		t.Fatalf("oversized request is rejected by idle budget, error %v", err)
+	}
+	if used := b.used.Load(); used != 1000 {
+		t.Errorf("budget usage is %d, expected 1000", used)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// lruCache represents in-memory LRU cache of rendered payloads bounded by
+// total size of cached data, nil cache is valid and caches nothing
+type lruCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List // cache entries from the most to the least recently used
+	entries  map[string]*list.Element
+}
+
+// cacheEntry represents entry of lruCache
+type cacheEntry struct {
+	key  string
+	data []byte
+}
+
+// payloadCache holds rendered payloads of deterministic requests, it is
+// enabled by Config.CacheSize
+var payloadCache *lruCache
+
+// helper function to create LRU cache of given size in bytes
+func newLRUCache(maxBytes int64) *lruCache {
+	return &lruCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// helper function to return cache key of payload with given ETag and
+// estimated size, empty key means payload is not cached, i.e. cache is
+// disabled, payload is not deterministic or it does not fit the cache,
+// such payloads are streamed rather than rendered in memory
+func (c *lruCache) key(etag string, estimate int64) string {
+	if c == nil || etag == "" || estimate > c.maxBytes {
+		return ""
+	}
+	return etag
+}
+
+// helper function to get cached data of given key
+func (c *lruCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).data, true
+}
+
+// helper function to cache data of given key, least recently used entries
+// are evicted to fit the data, data larger than the cache is not cached
+func (c *lruCache) add(key string, data []byte) {
+	if c == nil || int64(len(data)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	for c.size+int64(len(data)) > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, data: data})
+	c.size += int64(len(data))
+}
+
+// helper function to remove given element from the cache
+func (c *lruCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.data))
+}
+
+// WarmupRequest represents payload request generated into the cache at
+// startup, e.g. {"format": "json", "size": "10MB", "seed": 1}
+type WarmupRequest struct {
+	Format string `json:"format" yaml:"format"` // payload format
+	Size   string `json:"size" yaml:"size"`     // payload size, e.g. 10MB
+	Seed   int64  `json:"seed" yaml:"seed"`     // seed of the payload, only seeded payloads are cached
+}
+
+// helper function to return query of the payload request, requests with
+// the same parameters share the cache entry regardless of parameters order
+func (w WarmupRequest) query() string {
+	vals := url.Values{}
+	vals.Set("format", w.Format)
+	vals.Set("size", w.Size)
+	vals.Set("seed", strconv.FormatInt(w.Seed, 10))
+	return vals.Encode()
+}
+
+// helper function to generate given payloads into the cache, the server
+// reports it is not ready until warmup is complete
+func warmupCache(requests []WarmupRequest) {
+	defer setWarming(false)
+	start := time.Now()
+	for i, req := range requests {
+		t := time.Now()
+		r := httptest.NewRequest("GET", "/payload?"+req.query(), nil)
+		rec := httptest.NewRecorder()
+		PayloadHandler(rec, r)
+		if rec.Code != http.StatusOK {
+			msg := fmt.Sprintf("warmup request %d/%d %s failed with status %d, error %s", i+1, len(requests), req.query(), rec.Code, rec.Body.String())
+			logMessage("ERROR", msg, nil, 0)
+			continue
+		}
+		msg := fmt.Sprintf("warmup request %d/%d %s generated %d bytes in %v", i+1, len(requests), req.query(), rec.Body.Len(), time.Since(t))
+		logMessage("INFO", msg, nil, 0)
+	}
+	logMessage("INFO", fmt.Sprintf("warmup is complete in %v", time.Since(start)), nil, 0)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestLRUCacheKey tests that payloads are cached only by enabled cache
+// which is large enough to hold them
+func TestLRUCacheKey(t *testing.T) {
+	var disabled *lruCache
+	if key := disabled.key("etag", 1); key != "" {
+		t.Errorf("nil cache returned key %q, expected empty key", key)
+	}
+	c := newLRUCache(100)
+	if key := c.key("", 1); key != "" {
+		t.Errorf("cache returned key %q of payload without ETag, expected empty key", key)
+	}
+	if key := c.key("etag", 101); key != "" {
+		t.Errorf("cache returned key %q of oversized payload, expected empty key", key)
+	}
+	if key := c.key("etag", 100); key != "etag" {
+		t.Errorf("cache returned key %q, expected etag", key)
+	}
+}
+
+// TestLRUCacheEviction tests that least recently used entries are evicted
+// and data larger than the cache is not cached
+func TestLRUCacheEviction(t *testing.T) {
+	c := newLRUCache(10)
+	c.add("a", []byte("aaaa"))
+	c.add("b", []byte("bbbb"))
+	c.get("a")
+	c.add("c", []byte("cccc"))
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used entry b is not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("entry %s is evicted", key)
+		}
+	}
+	c.add("d", []byte("ddddddddddd"))
+	if _, ok := c.get("d"); ok {
+		t.Error("data larger than the cache is cached")
+	}
+}
+
+// TestPayloadCacheStreaming tests that seeded payloads are streamed rather
+// than buffered when cache is disabled or payload does not fit it
+func TestPayloadCacheStreaming(t *testing.T) {
+	defer func(c *lruCache) { payloadCache = c }(payloadCache)
+	tests := []struct {
+		name     string
+		cache    *lruCache
+		buffered bool
+	}{
+		{"disabled cache", nil, false},
+		{"oversized payload", newLRUCache(100), false},
+		{"cached payload", newLRUCache(1000 * 1000), true},
+	}
+	for _, tt := range tests {
+		payloadCache = tt.cache
+		for _, format := range []string{"ndjson", "sse"} {
+			r := httptest.NewRequest("GET", "/payload?format="+format+"&count=5&recordsize=100B&seed=1", nil)
+			w := httptest.NewRecorder()
+			PayloadHandler(w, r)
+			if w.Code != 200 {
+				t.Fatalf("%s: %s payload failed with status %d, error %s", tt.name, format, w.Code, w.Body.String())
+			}
+			buffered := w.Header().Get("Content-Length") != ""
+			if buffered != tt.buffered {
+				t.Errorf("%s: %s payload is buffered=%v, expected %v", tt.name, format, buffered, tt.buffered)
+			}
+			if !tt.buffered && !w.Flushed {
+				t.Errorf("%s: %s payload is not flushed", tt.name, format)
+			}
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration represents configuration structure of the server
+type Configuration struct {
+	Host              string            `json:"host" yaml:"host"` // bind address, empty means all interfaces
+	Port              int               `json:"port" yaml:"port"`
+	Ports             []int             `json:"ports" yaml:"ports"` // list of ports to listen on, takes precedence over port
+	ServerKey         string            `json:"serverkey" yaml:"serverkey"`
+	ServerCrt         string            `json:"servercrt" yaml:"servercrt"`
+	ShutdownTimeout   int               `json:"shutdowntimeout" yaml:"shutdowntimeout"`     // graceful shutdown timeout in seconds
+	MaxBodyBytes      int64             `json:"maxbodybytes" yaml:"maxbodybytes"`           // max size of request body, default 10MB
+	EnableH2C         bool              `json:"enableh2c" yaml:"enableh2c"`                 // enable HTTP/2 over cleartext on plain server
+	BasePath          string            `json:"basepath" yaml:"basepath"`                   // prefix of all endpoints, e.g. /httpgo
+	TLSMinVersion     string            `json:"tlsminversion" yaml:"tlsminversion"`         // minimum TLS version, e.g. TLS1.2 (default)
+	CipherSuites      []string          `json:"ciphersuites" yaml:"ciphersuites"`           // allowed cipher suites of TLS1.2 and earlier
+	ClientCA          string            `json:"clientca" yaml:"clientca"`                   // CA bundle to verify client certificates
+	ClientAuthMode    string            `json:"clientauthmode" yaml:"clientauthmode"`       // client auth mode, default requireandverify if ClientCA is set
+	MaxConcurrent     int               `json:"maxconcurrent" yaml:"maxconcurrent"`         // max number of in-flight payload requests, 0 is unlimited
+	LogFormat         string            `json:"logformat" yaml:"logformat"`                 // log format, text (default) or json
+	AllowedOrigins    []string          `json:"allowedorigins" yaml:"allowedorigins"`       // CORS allowed origins, use * to allow any origin
+	ExtraHeaders      map[string]string `json:"extraheaders" yaml:"extraheaders"`           // extra headers of payload responses
+	BasicAuthUser     string            `json:"basicauthuser" yaml:"basicauthuser"`         // basic auth user name, auth is enabled when user and password are set
+	BasicAuthPass     string            `json:"basicauthpass" yaml:"basicauthpass"`         // basic auth password
+	RateLimit         float64           `json:"ratelimit" yaml:"ratelimit"`                 // max requests per second of every client IP, 0 is unlimited
+	RateBurst         int               `json:"rateburst" yaml:"rateburst"`                 // max burst of client requests, default is ratelimit rounded up
+	ReadTimeout       string            `json:"readtimeout" yaml:"readtimeout"`             // max duration of reading request, e.g. 30s, empty or 0 disables it
+	WriteTimeout      string            `json:"writetimeout" yaml:"writetimeout"`           // max duration of writing response, empty or 0 disables it
+	IdleTimeout       string            `json:"idletimeout" yaml:"idletimeout"`             // max duration of idle keep-alive connection, empty or 0 disables it
+	EnablePprof       bool              `json:"enablepprof" yaml:"enablepprof"`             // expose profiling data under /debug/pprof, should be firewalled
+	StartupDelay      string            `json:"startupdelay" yaml:"startupdelay"`           // delay before server starts listening, e.g. 5s, empty or 0 disables it
+	MaxSize           string            `json:"maxsize" yaml:"maxsize"`                     // max size of requested payload, e.g. 1GB, empty means unlimited
+	RootMessage       string            `json:"rootmessage" yaml:"rootmessage"`             // body of GET / response, default is "Hello from Go"
+	HideRootHeaders   bool              `json:"hiderootheaders" yaml:"hiderootheaders"`     // do not dump request headers in GET / response
+	HandlerTimeout    string            `json:"handlertimeout" yaml:"handlertimeout"`       // max duration of request handling, e.g. 1m, empty or 0 disables it
+	UnixSocket        string            `json:"unixsocket" yaml:"unixsocket"`               // path of unix socket to listen on instead of TCP port
+	GenWorkers        int               `json:"genworkers" yaml:"genworkers"`               // number of parallel record generation workers, 0 or 1 is sequential
+	ServedBy          bool              `json:"servedby" yaml:"servedby"`                   // identify server by its host name in X-Served-By header and envelope
+	RecordTemplate    string            `json:"recordtemplate" yaml:"recordtemplate"`       // file with text/template rendering payload records as JSON objects
+	ShutdownToken     string            `json:"shutdowntoken" yaml:"shutdowntoken"`         // token required by /admin/shutdown endpoint, empty disables the endpoint
+	DisableKeepAlives bool              `json:"disablekeepalives" yaml:"disablekeepalives"` // close connections after every response
+	MaxTotalGenBytes  int64             `json:"maxtotalgenbytes" yaml:"maxtotalgenbytes"`   // budget of records generated by in-flight requests in bytes, 0 disables it
+	GenBudgetTimeout  string            `json:"genbudgettimeout" yaml:"genbudgettimeout"`   // max wait for generation budget before 503, default 10s
+	WarmupRequests    []WarmupRequest   `json:"warmuprequests" yaml:"warmuprequests"`       // payloads generated into the cache at startup, server is not ready until they are cached
+	DefaultFormat     string            `json:"defaultformat" yaml:"defaultformat"`         // payload format used when format parameter is omitted, e.g. json
+	ReusePort         bool              `json:"reuseport" yaml:"reuseport"`                 // listen with SO_REUSEPORT to share ports between processes, Linux only
+	GzipLevel         int               `json:"gziplevel" yaml:"gziplevel"`                 // gzip compression level 1-9, -1 or 0 means default compression
+	CacheSize         string            `json:"cachesize" yaml:"cachesize"`                 // max size of cached seeded payloads, e.g. 100MB, empty disables cache
+	TrustProxy        bool              `json:"trustproxy" yaml:"trustproxy"`               // use X-Forwarded-For header to identify clients
+}
+
+// defaultMaxBodyBytes defines default limit of request body size
+const defaultMaxBodyBytes = 10 * 1000 * 1000
+
+// Config is instance of Configruation
+var Config Configuration
+
+// Validate checks that configuration values are consistent
+func (c *Configuration) Validate() error {
+	if c.UnixSocket == "" {
+		seen := make(map[int]bool)
+		for _, port := range c.listenPorts() {
+			if port < 1 || port > 65535 {
+				return fmt.Errorf("invalid port %d, should be in 1-65535 range", port)
+			}
+			if seen[port] {
+				return fmt.Errorf("duplicate port %d in ports", port)
+			}
+			seen[port] = true
+		}
+	}
+	if (c.ServerKey == "") != (c.ServerCrt == "") {
+		return fmt.Errorf("both serverkey and servercrt should be provided to enable TLS, got serverkey=%q servercrt=%q", c.ServerKey, c.ServerCrt)
+	}
+	if (c.BasicAuthUser == "") != (c.BasicAuthPass == "") {
+		return fmt.Errorf("both basicauthuser and basicauthpass should be provided to enable basic auth")
+	}
+	if c.RateLimit < 0 || c.RateBurst < 0 {
+		return fmt.Errorf("invalid rate limit %v with burst %d, should be non-negative", c.RateLimit, c.RateBurst)
+	}
+	if _, err := parseDuration("readtimeout", c.ReadTimeout); err != nil {
+		return err
+	}
+	if _, err := parseDuration("writetimeout", c.WriteTimeout); err != nil {
+		return err
+	}
+	if _, err := parseDuration("idletimeout", c.IdleTimeout); err != nil {
+		return err
+	}
+	if _, err := parseDuration("startupdelay", c.StartupDelay); err != nil {
+		return err
+	}
+	if _, err := parseDuration("handlertimeout", c.HandlerTimeout); err != nil {
+		return err
+	}
+	if c.MaxSize != "" {
+		if _, err := parseSize(c.MaxSize); err != nil {
+			return fmt.Errorf("invalid maxsize value %q, error %v", c.MaxSize, err)
+		}
+	}
+	if c.MaxTotalGenBytes < 0 {
+		return fmt.Errorf("invalid maxtotalgenbytes %d, should be non-negative", c.MaxTotalGenBytes)
+	}
+	if _, err := parseDuration("genbudgettimeout", c.GenBudgetTimeout); err != nil {
+		return err
+	}
+	if c.GenWorkers < 0 {
+		return fmt.Errorf("invalid genworkers %d, should be non-negative", c.GenWorkers)
+	}
+	if c.CacheSize != "" {
+		if _, err := parseSize(c.CacheSize); err != nil {
+			return fmt.Errorf("invalid cachesize value %q, error %v", c.CacheSize, err)
+		}
+	}
+	if c.GzipLevel != 0 && c.GzipLevel != -1 && (c.GzipLevel < 1 || c.GzipLevel > 9) {
+		return fmt.Errorf("invalid gziplevel %d, should be in 1-9 range or -1 for default compression", c.GzipLevel)
+	}
+	if c.ReusePort && c.UnixSocket != "" {
+		return fmt.Errorf("reuseport is not supported for unixsocket")
+	}
+	if c.DefaultFormat != "" && !formats[c.DefaultFormat] {
+		return fmt.Errorf("unsupported defaultformat %q, should be one of %s", c.DefaultFormat, strings.Join(supportedFormats(), ", "))
+	}
+	if len(c.WarmupRequests) > 0 && c.CacheSize == "" {
+		return fmt.Errorf("warmuprequests require cachesize to be set")
+	}
+	for _, req := range c.WarmupRequests {
+		if !formats[req.Format] {
+			return fmt.Errorf("unsupported format %q of warmup request", req.Format)
+		}
+		if _, err := parseSize(req.Size); err != nil {
+			return fmt.Errorf("invalid size %q of warmup request, error %v", req.Size, err)
+		}
+	}
+	if c.LogFormat != "" && c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("unsupported log format %q, should be text or json", c.LogFormat)
+	}
+	for k, v := range c.ExtraHeaders {
+		if err := validateHeader(k, v); err != nil {
+			return err
+		}
+	}
+	if _, err := tlsVersion(c.TLSMinVersion); err != nil {
+		return err
+	}
+	if _, err := cipherSuites(c.CipherSuites); err != nil {
+		return err
+	}
+	if _, err := clientAuthMode(c.ClientAuthMode, c.ClientCA); err != nil {
+		return err
+	}
+	return nil
+}
+
+// helper function to return normalized base path of the server endpoints,
+// it always starts with slash and has no trailing slash, or it is empty
+func basePath() string {
+	base := strings.TrimSuffix(Config.BasePath, "/")
+	if base != "" && !strings.HasPrefix(base, "/") {
+		base = "/" + base
+	}
+	return base
+}
+
+// redactedValue replaces values of secret configuration fields in logs
+const redactedValue = "REDACTED"
+
+// helper function to return copy of configuration with secrets redacted,
+// file paths of keys and certificates are kept since they are not secrets
+func (c Configuration) redacted() Configuration {
+	if c.BasicAuthPass != "" {
+		c.BasicAuthPass = redactedValue
+	}
+	if c.ShutdownToken != "" {
+		c.ShutdownToken = redactedValue
+	}
+	return c
+}
+
+// helper function to return ports server listens on, the single port
+// is used when list of ports is not provided
+func (c *Configuration) listenPorts() []int {
+	if len(c.Ports) > 0 {
+		return c.Ports
+	}
+	return []int{c.Port}
+}
+
+// helper function to parse duration of given config option, the value
+// is a duration string, e.g. 30s or 1m, empty value means zero duration
+func parseDuration(name, val string) (time.Duration, error) {
+	if val == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s value %q, should be non-negative duration, e.g. 30s", name, val)
+	}
+	return d, nil
+}
+
+// helper function to set read, write and idle timeouts of given server,
+// note that write timeout covers whole handler execution including latency
+// simulation and bps throttling, e.g. latency=5s or 10MB at bps=1000000 would
+// be cut off by writetimeout below 5s or 10s respectively
+func setServerTimeouts(server *http.Server) error {
+	var err error
+	if server.ReadTimeout, err = parseDuration("readtimeout", Config.ReadTimeout); err != nil {
+		return err
+	}
+	if server.WriteTimeout, err = parseDuration("writetimeout", Config.WriteTimeout); err != nil {
+		return err
+	}
+	if server.IdleTimeout, err = parseDuration("idletimeout", Config.IdleTimeout); err != nil {
+		return err
+	}
+	return nil
+}
+
+// configFiles represents list of config files provided via repeatable -config flag
+type configFiles []string
+
+// String implements flag.Value interface
+func (c *configFiles) String() string {
+	return strings.Join(*c, ",")
+}
+
+// Set implements flag.Value interface and appends given config file to the list
+func (c *configFiles) Set(val string) error {
+	*c = append(*c, val)
+	return nil
+}
+
+// helper function to parse the config files and merge them in given order,
+// fields with non-zero values from later files override values of earlier
+// ones, e.g. -config base.json -config prod.json, since zero values are
+// not merged a later file can't reset a field to its zero value, e.g. false,
+// the built-in defaults are applied first so files may omit them
+func parseConfig(configFiles []string) error {
+	Config.Port = 8888
+	for _, configFile := range configFiles {
+		cfg, err := readConfig(configFile)
+		if err != nil {
+			return err
+		}
+		mergeConfig(&Config, cfg)
+	}
+	return nil
+}
+
+// helper function to merge non-zero fields of src configuration into dst one
+func mergeConfig(dst *Configuration, src Configuration) {
+	dval := reflect.ValueOf(dst).Elem()
+	sval := reflect.ValueOf(src)
+	for i := 0; i < sval.NumField(); i++ {
+		if !sval.Field(i).IsZero() {
+			dval.Field(i).Set(sval.Field(i))
+		}
+	}
+}
+
+// helper function to read the config file, the format of config file is
+// determined by its extension (.json, .yaml or .yml), for other extensions
+// we try JSON first and then YAML, the "-" file name means read config from stdin
+func readConfig(configFile string) (Configuration, error) {
+	var cfg Configuration
+	var data []byte
+	var err error
+	if configFile == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return cfg, fmt.Errorf("unable to read config from stdin, error %v", err)
+		}
+		configFile = "stdin"
+	} else {
+		data, err = ioutil.ReadFile(configFile)
+		if err != nil {
+			return cfg, fmt.Errorf("unable to read config file %s, error %v", configFile, err)
+		}
+	}
+	switch strings.ToLower(filepath.Ext(configFile)) {
+	case ".json":
+		err = json.Unmarshal(data, &cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &cfg)
+	default:
+		err = json.Unmarshal(data, &cfg)
+		if err != nil {
+			// reset partially parsed config before trying YAML
+			cfg = Configuration{}
+			if yerr := yaml.Unmarshal(data, &cfg); yerr != nil {
+				err = fmt.Errorf("not a valid JSON (%v) or YAML (%v)", err, yerr)
+			} else {
+				err = nil
+			}
+		}
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("unable to parse config file %s, error %v", configFile, err)
+	}
+	return cfg, nil
+}
+
+// helper function to override configuration values from environment variables,
+// the HTTPGO_PORT, HTTPGO_SERVERKEY and HTTPGO_SERVERCRT variables take precedence
+// over config file values which in turn take precedence over built-in defaults
+func applyEnvOverrides() error {
+	if v, ok := os.LookupEnv("HTTPGO_PORT"); ok {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid HTTPGO_PORT value %q, error %v", v, err)
+		}
+		// the variable defines single port, so it replaces ports list as well
+		Config.Port = port
+		Config.Ports = nil
+	}
+	if v, ok := os.LookupEnv("HTTPGO_SERVERKEY"); ok {
+		Config.ServerKey = v
+	}
+	if v, ok := os.LookupEnv("HTTPGO_SERVERCRT"); ok {
+		Config.ServerCrt = v
+	}
+	return nil
+}
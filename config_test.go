@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestParseConfigDefaultPort tests that built-in default port is used
+// when config files do not set it and file values override it
+func TestParseConfigDefaultPort(t *testing.T) {
+	defer func(c Configuration) { Config = c }(Config)
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		config string
+		port   int
+	}{
+		{"no port", `{"basepath":"/x"}`, 8888},
+		{"file port", `{"port":9000}`, 9000},
+	}
+	for _, tt := range tests {
+		Config = Configuration{}
+		fname := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(fname, []byte(tt.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := parseConfig([]string{fname}); err != nil {
+			t.Fatalf("%s: unable to parse config, error %v", tt.name, err)
+		}
+		if Config.Port != tt.port {
+			t.Errorf("%s: port %d, expected %d", tt.name, Config.Port, tt.port)
+		}
+		if err := Config.Validate(); err != nil {
+			t.Errorf("%s: invalid configuration, error %v", tt.name, err)
+		}
+	}
+}
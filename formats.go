@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// formats defines output formats supported by PayloadHandler
+var formats = map[string]bool{
+	"json":           true,
+	"ndjson":         true,
+	"csv":            true,
+	"xml":            true,
+	"sse":            true,
+	"lengthprefixed": true,
+}
+
+// lazyFormats defines formats which are streamed record by record, their
+// records are produced by generator while being written
+var lazyFormats = map[string]bool{
+	"json":           true,
+	"ndjson":         true,
+	"sse":            true,
+	"avro":           true,
+	"lengthprefixed": true,
+	"bson":           true,
+	"msgpack":        true,
+}
+
+// helper function to return sorted names of supported formats
+func supportedFormats() []string {
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// helper function to sanitize file name of downloaded payload, only letters,
+// digits, dots, dashes and underscores are kept to prevent header injection,
+// and the extension is replaced by the one of given format, e.g. data.json
+func downloadFilename(name, format string) string {
+	name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	name = strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_') {
+			return r
+		}
+		return '_'
+	}, name)
+	name = strings.Trim(name, ".")
+	if name == "" {
+		name = "payload"
+	}
+	return name + "." + format
+}
+
+// helper function to return Content-Type of given format, the ndjson
+// records framed by rs separator are served as JSON text sequences
+func contentType(format, separator string) string {
+	switch format {
+	case "json":
+		return "application/json"
+	case "ndjson":
+		if separator == "rs" {
+			return "application/json-seq"
+		}
+		return "application/x-ndjson"
+	case "sse":
+		return "text/event-stream"
+	case "csv":
+		return "text/csv"
+	case "xml":
+		return "application/xml"
+	case "msgpack":
+		return "application/msgpack"
+	case "avro":
+		return "application/avro"
+	case "lengthprefixed":
+		return "application/octet-stream"
+	case "bson":
+		return "application/bson"
+	}
+	return "application/octet-stream"
+}
+
+// flushRecords defines how often (in number of records) streamed output is flushed
+const flushRecords = 100
+
+// helper function to flush given writer if it supports http.Flusher interface
+func flush(w io.Writer) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// helper function to stream records produced lazily by given generator as
+// JSON array, records are written as they are generated so memory usage stays
+// flat regardless of number of records, the output is identical to
+// json.Marshal(records) or json.MarshalIndent(records, "", "  ") with indent option
+func writeJSON(w io.Writer, gen *recordGenerator, indent bool) error {
+	return writeJSONArray(w, gen.next, "", indent)
+}
+
+// helper function to write JSON array of records taken one by one from given
+// function, with indent option the prefix is prepended to every line except
+// the first one as in json.MarshalIndent
+func writeJSONArray(w io.Writer, next func() (Record, bool, error), prefix string, indent bool) error {
+	open, sep, end := "[", ",", "]"
+	if indent {
+		open, sep, end = "[\n"+prefix+"  ", ",\n"+prefix+"  ", "\n"+prefix+"]"
+	}
+	n := 0
+	for ; ; n++ {
+		rec, ok, err := next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		var data []byte
+		if indent {
+			data, err = json.MarshalIndent(rec, prefix+"  ", "  ")
+		} else {
+			data, err = json.Marshal(rec)
+		}
+		if err != nil {
+			return err
+		}
+		delim := sep
+		if n == 0 {
+			delim = open
+		}
+		if _, err := io.WriteString(w, delim); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if n > 0 && n%flushRecords == 0 {
+			flush(w)
+		}
+	}
+	if n == 0 {
+		end = "[]"
+	}
+	_, err := io.WriteString(w, end)
+	return err
+}
+
+// helper function to iterate over given records as generator does
+func sliceRecords(records []Record) func() (Record, bool, error) {
+	return func() (Record, bool, error) {
+		if len(records) == 0 {
+			return nil, false, nil
+		}
+		rec := records[0]
+		records = records[1:]
+		return rec, true, nil
+	}
+}
+
+// envelopeMeta represents metadata of enveloped JSON output
+type envelopeMeta struct {
+	Count       int    `json:"count"`
+	GeneratedAt string `json:"generated_at"`
+	ServedBy    string `json:"served_by,omitempty"`
+}
+
+// helper function to stream records wrapped into envelope object, e.g.
+// {"meta":{"count":N,"generated_at":"..."},"records":[...]}
+// with indent option the output is identical to json.MarshalIndent of the envelope,
+// number of records produced up to target size is known only after generation,
+// so in size mode records are generated before being written
+func writeEnvelope(w io.Writer, gen *recordGenerator, indent bool) error {
+	count, next := gen.count, gen.next
+	if count < 0 {
+		records, err := gen.all()
+		if err != nil {
+			return err
+		}
+		count, next = len(records), sliceRecords(records)
+	}
+	meta := envelopeMeta{
+		Count:       count,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		ServedBy:    servedBy(),
+	}
+	if !indent {
+		data, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, `{"meta":%s,"records":`, data); err != nil {
+			return err
+		}
+		if err := writeJSONArray(w, next, "", false); err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, "}")
+		return err
+	}
+	data, err := json.MarshalIndent(meta, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "{\n  \"meta\": %s,\n  \"records\": ", data); err != nil {
+		return err
+	}
+	if err := writeJSONArray(w, next, "  ", true); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n}")
+	return err
+}
+
+// helper function to stream records produced lazily by given generator, every
+// record is written by given function along with its sequence number and the
+// output is flushed after it so clients can process records incrementally,
+// non-zero delay is applied between records to simulate slow trickling stream,
+// streaming stops when given context is done, e.g. client has gone away
+func streamRecords(ctx context.Context, w io.Writer, gen *recordGenerator, delay time.Duration, write func(io.Writer, int, Record) error) error {
+	for seq := 0; ; seq++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if seq > 0 && delay > 0 {
+			if err := sleepContext(ctx, delay); err != nil {
+				return err
+			}
+		}
+		rec, ok, err := gen.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if err := write(w, seq, rec); err != nil {
+			return err
+		}
+		flush(w)
+	}
+}
+
+// recordSeparator represents framing of records in ndjson output
+type recordSeparator struct {
+	Prefix string // written before every record
+	Suffix string // written after every record
+}
+
+// recordSeparators defines supported framings of ndjson records, the rs
+// separator produces JSON text sequences (RFC 7464), i.e. <RS>{json}<LF>
+var recordSeparators = map[string]recordSeparator{
+	"lf":   {Suffix: "\n"},
+	"crlf": {Suffix: "\r\n"},
+	"rs":   {Prefix: "\x1e", Suffix: "\n"},
+}
+
+// helper function to stream records in ndjson format with given separator
+func writeNDJSON(ctx context.Context, w io.Writer, gen *recordGenerator, delay time.Duration, sep recordSeparator) error {
+	return streamRecords(ctx, w, gen, delay, func(w io.Writer, seq int, rec Record) error {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, sep.Prefix+string(data)+sep.Suffix)
+		return err
+	})
+}
+
+// helper function to stream records as Server-Sent Events, every record is
+// sent as separate event with incrementing id, e.g. "id: 0\ndata: {...}\n\n"
+func writeSSE(ctx context.Context, w io.Writer, gen *recordGenerator, delay time.Duration) error {
+	return streamRecords(ctx, w, gen, delay, func(w io.Writer, seq int, rec Record) error {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", seq, data)
+		return err
+	})
+}
+
+// helper function to stream records as length-prefixed frames, every record
+// is JSON encoded and preceded by its length as 4-byte big-endian integer,
+// similar to gRPC message framing without compression flag
+func writeLengthPrefixed(ctx context.Context, w io.Writer, gen *recordGenerator, delay time.Duration) error {
+	return streamRecords(ctx, w, gen, delay, func(w io.Writer, seq int, rec Record) error {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if uint64(len(data)) > math.MaxUint32 {
+			return fmt.Errorf("record %d of %d bytes exceeds max frame length %d", seq, len(data), uint32(math.MaxUint32))
+		}
+		var prefix [4]byte
+		binary.BigEndian.PutUint32(prefix[:], uint32(len(data)))
+		if _, err := w.Write(prefix[:]); err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+}
+
+// helper function to return sorted union of keys across given records
+func recordKeys(records []Record) []string {
+	set := make(map[string]bool)
+	for _, rec := range records {
+		for k := range rec {
+			set[k] = true
+		}
+	}
+	var keys []string
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// helper function to convert record value into its string representation,
+// byte slices are base64 encoded, floats are printed without exponent and
+// nested records, objects and arrays, e.g. rendered by record template, are
+// JSON encoded to match JSON output
+func stringValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []byte:
+		return base64.StdEncoding.EncodeToString(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case Record, map[string]interface{}, []interface{}:
+		// nested records are represented as JSON objects
+		data, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprintf("%v", val)
+		}
+		return string(data)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// helper function to write records in CSV format (RFC 4180)
+// the header row is composed from sorted union of record keys
+func writeCSV(w io.Writer, records []Record) error {
+	keys := recordKeys(records)
+	writer := csv.NewWriter(w)
+	if err := writer.Write(keys); err != nil {
+		return err
+	}
+	row := make([]string, len(keys))
+	for _, rec := range records {
+		for i, k := range keys {
+			row[i] = stringValue(rec[k])
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// helper function to check that given record key can be used as XML element
+// name, i.e. it starts with a letter or underscore followed by letters, digits,
+// dots, dashes or underscores
+func isXMLName(name string) bool {
+	for i, r := range name {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || (!unicode.IsDigit(r) && r != '.' && r != '-')) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// helper function to validate that record keys of given generator options
+// are valid XML element names since keys are written as XML elements
+func validateXMLNames(opts genOptions) error {
+	if opts.IDField != "" && !isXMLName(opts.IDField) {
+		return fmt.Errorf("unsupported idfield %q of xml format, should be valid XML name", opts.IDField)
+	}
+	for name := range opts.Schema {
+		if !isXMLName(name) {
+			return fmt.Errorf("unsupported schema field %q of xml format, should be valid XML name", name)
+		}
+	}
+	return nil
+}
+
+// helper function to write records in XML format, e.g.
+// <records><record><data>...</data><id>0</id></record>...</records>
+// record fields are written in sorted order of their keys which have to
+// be valid XML names, e.g. keys of templated records are checked here
+func writeXML(w io.Writer, records []Record) error {
+	for _, k := range recordKeys(records) {
+		if !isXMLName(k) {
+			return fmt.Errorf("record key %q is not valid XML name", k)
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	root := xml.StartElement{Name: xml.Name{Local: "records"}}
+	if err := enc.EncodeToken(root); err != nil {
+		return err
+	}
+	item := xml.StartElement{Name: xml.Name{Local: "record"}}
+	for _, rec := range records {
+		if err := enc.EncodeToken(item); err != nil {
+			return err
+		}
+		for _, k := range recordKeys([]Record{rec}) {
+			field := xml.StartElement{Name: xml.Name{Local: k}}
+			if err := enc.EncodeElement(stringValue(rec[k]), field); err != nil {
+				return err
+			}
+		}
+		if err := enc.EncodeToken(item.End()); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeToken(root.End()); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
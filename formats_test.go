@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"testing"
+)
+
+// TestWriteJSON tests that records streamed as JSON array are identical to
+// the array marshaled at once
+func TestWriteJSON(t *testing.T) {
+	for _, count := range []int{0, 1, 5} {
+		records := testRecords(t, count, 0, 1)
+		for _, indent := range []bool{false, true} {
+			var buf bytes.Buffer
+			opts := genOptions{Rand: rand.New(rand.NewSource(1)), DataSize: 16}
+			if err := writeJSON(&buf, newRecordGenerator(count, 0, opts), indent); err != nil {
+				t.Fatal(err)
+			}
+			expect, err := json.Marshal(records)
+			if indent {
+				expect, err = json.MarshalIndent(records, "", "  ")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), expect) {
+				t.Errorf("count=%d indent=%v: written JSON differs from marshaled one\n%s\n%s", count, indent, buf.Bytes(), expect)
+			}
+		}
+	}
+}
+
+// TestWriteEnvelope tests that enveloped records are identical to the
+// envelope marshaled at once in both count and size modes
+func TestWriteEnvelope(t *testing.T) {
+	for _, tt := range []struct {
+		count  int
+		target int64
+	}{{3, 0}, {-1, 500}} {
+		records := testRecords(t, tt.count, tt.target, 1)
+		for _, indent := range []bool{false, true} {
+			var buf bytes.Buffer
+			opts := genOptions{Rand: rand.New(rand.NewSource(1)), DataSize: 16}
+			if err := writeEnvelope(&buf, newRecordGenerator(tt.count, tt.target, opts), indent); err != nil {
+				t.Fatal(err)
+			}
+			var env struct {
+				Meta    envelopeMeta `json:"meta"`
+				Records []Record     `json:"records"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &env); err != nil {
+				t.Fatalf("count=%d indent=%v: invalid envelope %s, error %v", tt.count, indent, buf.Bytes(), err)
+			}
+			if env.Meta.Count != len(records) || len(env.Records) != len(records) {
+				t.Errorf("count=%d indent=%v: envelope declares %d and holds %d records, expected %d", tt.count, indent, env.Meta.Count, len(env.Records), len(records))
+			}
+			expect, err := json.MarshalIndent(env, "", "  ")
+			if !indent {
+				expect, err = json.Marshal(env)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), expect) {
+				t.Errorf("count=%d indent=%v: written envelope differs from marshaled one\n%s\n%s", tt.count, indent, buf.Bytes(), expect)
+			}
+		}
+	}
+}
+
+// TestIsXMLName tests validation of record keys written as XML elements
+func TestIsXMLName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"id", true},
+		{"_id", true},
+		{"a1.b-c_d", true},
+		{"", false},
+		{"a b", false},
+		{"1a", false},
+		{"-a", false},
+		{"a<b", false},
+	}
+	for _, tt := range tests {
+		if valid := isXMLName(tt.name); valid != tt.valid {
+			t.Errorf("isXMLName(%q) = %v, expected %v", tt.name, valid, tt.valid)
+		}
+	}
+}
@@ -1,3 +1,20 @@
 module github.com/vkuznet/httpgo
 
 go 1.20
+
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/linkedin/goavro/v2 v2.15.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	go.mongodb.org/mongo-driver v1.17.3
+	golang.org/x/net v0.35.0
+	golang.org/x/sys v0.30.0
+	golang.org/x/time v0.8.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/golang/snappy v0.0.4 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+)
@@ -1,168 +1,1106 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/http/pprof"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
-// Configuration represents configuration structure of the server
-type Configuration struct {
-	Port      int    `json:"port"`
-	ServerKey string `json:"serverkey"`
-	ServerCrt string `json:"servercrt"`
+// version represents version of the server
+var version string
+
+// StartTime represents start time of the server
+var StartTime time.Time
+
+// readiness represents readiness state of the server, the server is not
+// ready when it is draining before shutdown or warming up the cache
+var readiness = struct {
+	sync.RWMutex
+	ready   bool
+	warming bool
+}{ready: true}
+
+// helper function to set readiness state of the server
+func setReady(ready bool) {
+	readiness.Lock()
+	defer readiness.Unlock()
+	readiness.ready = ready
 }
 
-// Config is instance of Configruation
-var Config Configuration
+// helper function to set warmup state of the server
+func setWarming(warming bool) {
+	readiness.Lock()
+	defer readiness.Unlock()
+	readiness.warming = warming
+}
 
-// version represents version of the server
-var version string
+// helper function to check if the server is warming up the cache
+func isWarming() bool {
+	readiness.RLock()
+	defer readiness.RUnlock()
+	return readiness.warming
+}
 
-// Record represent generic record
-type Record map[string]interface{}
+// helper function to check readiness state of the server
+func isReady() bool {
+	readiness.RLock()
+	defer readiness.RUnlock()
+	return readiness.ready
+}
 
-// helper function to generate series of records for given number of rows
-func genNRecords(total int) []Record {
-	var records []Record
-	slice := make([]byte, 1024)
-	size := runtime.Stack(slice, false)
-	for i := 0; i < total; i++ {
-		rec := make(Record)
-		rec["id"] = i
-		rec["data"] = slice[0:size]
-		records = append(records, rec)
+// HTTPError function dumpt http error of given request to log and return back to user
+// with given status code, e.g. http.StatusBadRequest for invalid user input
+// and http.StatusInternalServerError for server side failures
+func HTTPError(label, msg string, code int, w http.ResponseWriter, r *http.Request) {
+	logMessage(label, msg, r, code)
+	w.WriteHeader(code)
+	w.Write([]byte(msg))
+}
+
+// compressWriter represents streaming compressor of response data
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// helper function to create gzip compressor with Config.GzipLevel
+// compression level, zero level means default compression
+func newGzipWriter(w io.Writer) compressWriter {
+	level := Config.GzipLevel
+	if level == 0 {
+		level = gzip.DefaultCompression
 	}
-	return records
+	gw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		// level is validated at startup, fall back to default compression
+		return gzip.NewWriter(w)
+	}
+	return gw
 }
 
-// helper function to generate series of records totaling in size to given value
-func genRecords(size string) ([]Record, error) {
-	var records []Record
-	var suffix string
-	if strings.HasSuffix(size, "KB") {
-		suffix = "KB"
-	} else if strings.HasSuffix(size, "MB") {
-		suffix = "MB"
-	} else if strings.HasSuffix(size, "GB") {
-		suffix = "GB"
-	} else {
-		return nil, errors.New("unsupported size, should be KB, MB or GB units")
+// compressors defines content encodings supported by payload responses
+var compressors = map[string]func(io.Writer) compressWriter{
+	"gzip": newGzipWriter,
+	// HTTP deflate encoding is zlib format (RFC 1950) rather than raw deflate
+	"deflate": func(w io.Writer) compressWriter { return zlib.NewWriter(w) },
+}
+
+// compressResponseWriter wraps http.ResponseWriter and compresses its output
+type compressResponseWriter struct {
+	http.ResponseWriter
+	cw compressWriter
+}
+
+// Write implements io.Writer interface and writes data to compressor
+func (w compressResponseWriter) Write(b []byte) (int, error) {
+	return w.cw.Write(b)
+}
+
+// Flush implements http.Flusher interface and flushes compressed data to the client
+func (w compressResponseWriter) Flush() {
+	w.cw.Flush()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// countingWriter wraps http.ResponseWriter, it discards written data and
+// counts its length, it is used to compute Content-Length of HEAD responses
+type countingWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+// Write implements io.Writer interface and counts length of discarded data
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.written += int64(len(b))
+	return len(b), nil
+}
+
+// Flush implements http.Flusher interface, there is nothing to flush
+func (w *countingWriter) Flush() {}
+
+// throttledWriter wraps http.ResponseWriter and limits its write rate to
+// given number of bytes per second
+type throttledWriter struct {
+	http.ResponseWriter
+	bps     int64
+	start   time.Time
+	written int64
+}
+
+// Write implements io.Writer interface, it writes data in small chunks and
+// sleeps between them to maintain the target rate
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	if w.start.IsZero() {
+		w.start = time.Now()
+	}
+	// use chunks of ~100ms worth of data to keep the rate smooth
+	chunk := int(w.bps / 10)
+	if chunk < 1 {
+		chunk = 1
+	}
+	var total int
+	for len(b) > 0 {
+		size := chunk
+		if size > len(b) {
+			size = len(b)
+		}
+		n, err := w.ResponseWriter.Write(b[:size])
+		total += n
+		w.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		b = b[size:]
+		if f, ok := w.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		expected := time.Duration(float64(w.written) / float64(w.bps) * float64(time.Second))
+		if delay := expected - time.Since(w.start); delay > 0 {
+			time.Sleep(delay)
+		}
+	}
+	return total, nil
+}
+
+// Flush implements http.Flusher interface if underlying writer supports it
+func (w *throttledWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns underlying http.ResponseWriter to http.ResponseController
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// helper function to check if client accepts gzip encoding
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		arr := strings.Split(strings.TrimSpace(v), ";")
+		if strings.ToLower(strings.TrimSpace(arr[0])) != "gzip" {
+			continue
+		}
+		// check that client did not explicitly disable gzip via q=0
+		for _, p := range arr[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if q, err := strconv.ParseFloat(p[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
 	}
-	arr := strings.Split(size, suffix)
-	total, err := strconv.Atoi(arr[0])
+	return false
+}
+
+// helper function to validate name and value of response header, it rejects
+// invalid names and values with CR/LF characters to avoid response splitting
+func validateHeader(name, value string) error {
+	if !httpguts.ValidHeaderFieldName(name) {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if strings.ContainsAny(value, "\r\n") || !httpguts.ValidHeaderFieldValue(value) {
+		return fmt.Errorf("invalid value %q of header %q", value, name)
+	}
+	return nil
+}
+
+// helper function to write buffered response with Content-Length header,
+// the data is compressed in memory with given content encoding if any,
+// non-zero shortBy declares Content-Length larger than written data by
+// given number of bytes to simulate servers which under-deliver
+func writeBuffered(w http.ResponseWriter, r *http.Request, data []byte, encoding string, shortBy int64) {
+	if encoding != "" {
+		var buf bytes.Buffer
+		cw := compressors[encoding](&buf)
+		_, err := cw.Write(data)
+		if err == nil {
+			err = cw.Close()
+		}
+		if err != nil {
+			msg := fmt.Sprintf("unable to compress response, error %v", err)
+			HTTPError("ERROR", msg, http.StatusInternalServerError, w, r)
+			return
+		}
+		w.Header().Set("Content-Encoding", encoding)
+		data = buf.Bytes()
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(int64(len(data))+shortBy, 10))
+	w.Write(data)
+}
+
+// maxChunkSize defines max size of chunks of chunkedWriter
+const maxChunkSize = 16 * 1024 * 1024
+
+// chunkedWriter wraps http.ResponseWriter and writes data in chunks of
+// fixed size, every chunk is flushed to be sent as separate chunk of
+// chunked transfer encoding, the last chunk may be smaller
+type chunkedWriter struct {
+	http.ResponseWriter
+	buf  []byte
+	size int
+}
+
+// Write implements io.Writer interface, it buffers data and writes it out
+// whenever the chunk is full
+func (w *chunkedWriter) Write(b []byte) (int, error) {
+	var total int
+	for len(b) > 0 {
+		n := copy(w.buf[len(w.buf):w.size], b)
+		w.buf = w.buf[:len(w.buf)+n]
+		total += n
+		b = b[n:]
+		if len(w.buf) == w.size {
+			if err := w.writeChunk(); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// helper function to write buffered chunk and flush it
+func (w *chunkedWriter) writeChunk() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf)
+	w.buf = w.buf[:0]
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if suffix == "MB" {
-		total *= 1000
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
 	}
-	if suffix == "GB" {
-		total *= 1000 * 1000
+	return nil
+}
+
+// Flush implements http.Flusher interface, it does nothing to keep chunk
+// boundaries predictable, buffered data is written by finish
+func (w *chunkedWriter) Flush() {}
+
+// helper function to write remaining data as the last chunk
+func (w *chunkedWriter) finish() {
+	w.writeChunk()
+}
+
+// Unwrap returns underlying http.ResponseWriter to http.ResponseController
+func (w *chunkedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// errTruncated is returned by truncatingWriter once its limit is reached
+var errTruncated = errors.New("response truncated")
+
+// truncatingWriter wraps http.ResponseWriter and fails writes once given
+// number of bytes is written, it is used to simulate interrupted streams
+type truncatingWriter struct {
+	http.ResponseWriter
+	limit   int64
+	written int64
+}
+
+// Write implements io.Writer interface, it writes data up to the limit
+// and returns errTruncated when the limit is reached
+func (w *truncatingWriter) Write(b []byte) (int, error) {
+	if remaining := w.limit - w.written; int64(len(b)) >= remaining {
+		n, err := w.ResponseWriter.Write(b[:remaining])
+		w.written += int64(n)
+		if err != nil {
+			return n, err
+		}
+		return n, errTruncated
 	}
-	records = genNRecords(total)
-	return records, nil
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
 }
 
-// HTTPError function dumpt http error to log and return back to user
-func HTTPError(label, msg string, w http.ResponseWriter) {
-	log.Println(label, msg)
-	w.WriteHeader(http.StatusInternalServerError)
-	w.Write([]byte(msg))
+// Flush implements http.Flusher interface if underlying writer supports it
+func (w *truncatingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns underlying http.ResponseWriter to http.ResponseController
+func (w *truncatingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// helper function to abruptly close connection of the response, written
+// data is flushed and connection is hijacked and closed, so client does
+// not get a clean end of the response, e.g. final chunk of chunked encoding.
+// It requires http.Hijacker capable server, e.g. HTTP/1.x one, otherwise
+// the handler is aborted with http.ErrAbortHandler which resets HTTP/2 stream
+func abortConnection(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.Flush()
+	conn, _, err := rc.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	conn.Close()
+}
+
+// helper function to compute ETag of payload from request parameters and
+// schema, it is only meaningful for seeded requests whose output is
+// deterministic, the ETag is weak since content encoding may differ
+func payloadETag(r *http.Request, schema Schema) string {
+	hash := sha256.New()
+	// encoded query parameters are sorted by key
+	io.WriteString(hash, r.URL.Query().Encode())
+	if data, err := json.Marshal(schema); err == nil {
+		hash.Write(data)
+	}
+	return fmt.Sprintf("W/\"%x\"", hash.Sum(nil)[:16])
+}
+
+// helper function to check if If-None-Match header matches given ETag,
+// the header may contain list of ETags or *, weak comparison is used
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to log error of writing payload records, writers stop on
+// first write error so the log shows how many records were produced before
+// client has gone away, e.g. broken pipe, or request has timed out
+func logWriteError(r *http.Request, format string, err error, records int) {
+	if r.Context().Err() != nil || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+		msg := fmt.Sprintf("%s stream to %s truncated after producing %d records, error %v", format, r.RemoteAddr, records, err)
+		logMessage("INFO", msg, r, 0)
+		return
+	}
+	msg := fmt.Sprintf("unable to write %s records to %s after producing %d records, error %v", format, r.RemoteAddr, records, err)
+	logMessage("ERROR", msg, r, 0)
 }
 
 // PayloadHandler provides API to test the payload
 func PayloadHandler(w http.ResponseWriter, r *http.Request) {
-	var latency int
+	var latency, jitter, recordDelay, continueDelay time.Duration
+	var dist latencyDist
 	var size string
 	var format string
+	var fill string
+	var download string
+	var compress string
+	var mimeType string
+	separator := "lf"
+	var errorRate, compressibility float64
+	var schema Schema
+	var buffer, indent, envelope, trailers, closeConn bool
+	var recordSize, depth, idStart int
+	var idField, idType string
+	var timestamp, timestampMode string
+	var bps, truncateAt, shortBy int64
+	var chunkSize int
+	headers := make(map[string]string)
+	count := -1
+	seed := time.Now().UnixNano()
+	var seeded bool
 	for k, values := range r.URL.Query() {
 		if k == "latency" {
-			v, err := strconv.Atoi(values[0])
+			v, err := parseLatency(values[0])
 			if err == nil {
 				latency = v
 			} else {
 				msg := fmt.Sprintf("unable to convert latency value, error %v", err)
-				HTTPError("ERROR", msg, w)
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "jitter" {
+			v, err := parseLatency(values[0])
+			if err == nil {
+				jitter = v
+			} else {
+				msg := fmt.Sprintf("unable to convert jitter value, error %v", err)
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "perrecorddelay" {
+			v, err := parseLatency(values[0])
+			if err == nil {
+				recordDelay = v
+			} else {
+				msg := fmt.Sprintf("unable to convert perrecorddelay value, error %v", err)
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "continuedelay" {
+			v, err := parseLatency(values[0])
+			if err == nil {
+				continueDelay = v
+			} else {
+				msg := fmt.Sprintf("unable to convert continuedelay value, error %v", err)
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
 				return
 			}
+		} else if k == "latencydist" {
+			dist.Name = values[0]
+		} else if k == "mean" || k == "stddev" || k == "min" || k == "max" {
+			v, err := parseLatency(values[0])
+			if err != nil {
+				msg := fmt.Sprintf("unable to convert %s value, error %v", k, err)
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+			switch k {
+			case "mean":
+				dist.Mean = v
+			case "stddev":
+				dist.Stddev = v
+			case "min":
+				dist.Min = v
+			case "max":
+				dist.Max = v
+			}
 		} else if k == "size" {
 			size = values[0]
+		} else if k == "count" {
+			v, err := strconv.Atoi(values[0])
+			if err == nil && v >= 0 {
+				count = v
+			} else {
+				msg := fmt.Sprintf("invalid count value %q, should be non-negative integer", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "recordsize" {
+			v, err := parseSize(values[0])
+			if err == nil && v > 0 && v <= math.MaxInt32 {
+				recordSize = int(v)
+			} else {
+				msg := fmt.Sprintf("invalid recordsize value %q, should be positive size in B, KB, MB, GB, KiB, MiB or GiB units", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "bps" {
+			v, err := strconv.ParseInt(values[0], 10, 64)
+			if err == nil && v > 0 {
+				bps = v
+			} else {
+				msg := fmt.Sprintf("invalid bps value %q, should be positive integer", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "truncateat" {
+			// plain number of bytes or size with units, e.g. 100 or 1KB
+			v, err := strconv.ParseInt(values[0], 10, 64)
+			if err != nil {
+				v, err = parseSize(values[0])
+			}
+			if err == nil && v > 0 {
+				truncateAt = v
+			} else {
+				msg := fmt.Sprintf("invalid truncateat value %q, should be positive number of bytes or size in B, KB, MB, GB, KiB, MiB or GiB units", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "shortby" {
+			v, err := strconv.ParseInt(values[0], 10, 64)
+			if err == nil && v > 0 {
+				shortBy = v
+			} else {
+				msg := fmt.Sprintf("invalid shortby value %q, should be positive integer", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "chunksize" {
+			// plain number of bytes or size with units, e.g. 100 or 1KB
+			v, err := strconv.ParseInt(values[0], 10, 64)
+			if err != nil {
+				v, err = parseSize(values[0])
+			}
+			if err == nil && v > 0 && v <= maxChunkSize {
+				chunkSize = int(v)
+			} else {
+				msg := fmt.Sprintf("invalid chunksize value %q, should be positive number of bytes or size in B, KB, MB, GB, KiB, MiB or GiB units up to %d bytes", values[0], maxChunkSize)
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "header" {
+			for _, v := range values {
+				arr := strings.SplitN(v, ":", 2)
+				if len(arr) != 2 {
+					msg := fmt.Sprintf("invalid header value %q, should be in name:value form", v)
+					HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+					return
+				}
+				name, value := strings.TrimSpace(arr[0]), strings.TrimSpace(arr[1])
+				if err := validateHeader(name, value); err != nil {
+					HTTPError("ERROR", err.Error(), http.StatusBadRequest, w, r)
+					return
+				}
+				headers[name] = value
+			}
+		} else if k == "contenttype" {
+			if err := validateHeader("Content-Type", values[0]); err != nil {
+				HTTPError("ERROR", err.Error(), http.StatusBadRequest, w, r)
+				return
+			}
+			mimeType = values[0]
 		} else if k == "format" {
 			format = values[0]
+		} else if k == "download" {
+			download = values[0]
+		} else if k == "compress" {
+			if _, ok := compressors[values[0]]; !ok {
+				msg := fmt.Sprintf("unsupported compress value %q", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+			compress = values[0]
+		} else if k == "depth" {
+			v, err := strconv.Atoi(values[0])
+			if err == nil && v >= 0 && v <= maxDepth {
+				depth = v
+			} else {
+				msg := fmt.Sprintf("invalid depth value %q, should be integer in 0-%d range", values[0], maxDepth)
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "idfield" {
+			idField = values[0]
+		} else if k == "idtype" {
+			idType = values[0]
+		} else if k == "timestamp" {
+			timestamp = values[0]
+		} else if k == "timestampmode" {
+			timestampMode = values[0]
+		} else if k == "idstart" {
+			v, err := strconv.Atoi(values[0])
+			if err == nil && v >= 0 {
+				idStart = v
+			} else {
+				msg := fmt.Sprintf("invalid idstart value %q, should be non-negative integer", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "separator" {
+			if _, ok := recordSeparators[values[0]]; !ok {
+				msg := fmt.Sprintf("invalid separator value %q, should be lf, crlf or rs", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+			separator = values[0]
+		} else if k == "fill" {
+			fill = values[0]
+		} else if k == "compressibility" {
+			v, err := strconv.ParseFloat(values[0], 64)
+			if err == nil && v >= 0 && v <= 1 {
+				compressibility = v
+			} else {
+				msg := fmt.Sprintf("invalid compressibility value %q, should be float in 0-1 range", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "errorrate" {
+			v, err := strconv.ParseFloat(values[0], 64)
+			if err == nil && v >= 0 && v <= 1 {
+				errorRate = v
+			} else {
+				msg := fmt.Sprintf("invalid errorrate value %q, should be float in 0-1 range", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "schema" {
+			err := json.Unmarshal([]byte(values[0]), &schema)
+			if err != nil {
+				msg := fmt.Sprintf("unable to parse schema, error %v", err)
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "buffer" {
+			v, err := strconv.ParseBool(values[0])
+			if err == nil {
+				buffer = v
+			} else {
+				msg := fmt.Sprintf("invalid buffer value %q, should be true or false", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "indent" {
+			v, err := strconv.ParseBool(values[0])
+			if err == nil {
+				indent = v
+			} else {
+				msg := fmt.Sprintf("invalid indent value %q, should be true or false", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "envelope" {
+			v, err := strconv.ParseBool(values[0])
+			if err == nil {
+				envelope = v
+			} else {
+				msg := fmt.Sprintf("invalid envelope value %q, should be true or false", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "connection" {
+			if values[0] != "close" {
+				msg := fmt.Sprintf("invalid connection value %q, should be close", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+			closeConn = true
+		} else if k == "trailers" {
+			v, err := strconv.ParseBool(values[0])
+			if err == nil {
+				trailers = v
+			} else {
+				msg := fmt.Sprintf("invalid trailers value %q, should be true or false", values[0])
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
+		} else if k == "seed" {
+			v, err := strconv.ParseInt(values[0], 10, 64)
+			if err == nil {
+				seed = v
+				seeded = true
+			} else {
+				msg := fmt.Sprintf("unable to convert seed value, error %v", err)
+				HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+				return
+			}
 		}
 	}
-	if latency > 0 {
-		time.Sleep(time.Duration(latency) * time.Second)
+	// schema can be also provided in body of POST request
+	if schema == nil && r.Method == "POST" {
+		// net/http sends 100 Continue to clients which sent Expect: 100-continue
+		// header on first read of the body, so the interim response is delayed
+		// by postponing the read. Clients should wait for it longer than given
+		// delay, e.g. Transport.ExpectContinueTimeout of Go clients or
+		// --expect100-timeout of curl otherwise they send the body anyway,
+		// curl sends Expect header for large bodies or with -H "Expect: 100-continue"
+		if continueDelay > 0 {
+			if err := sleepContext(r.Context(), continueDelay); err != nil {
+				handleTimeout(w, r, err, fmt.Sprintf("continuedelay %v", continueDelay))
+				return
+			}
+		}
+		defer r.Body.Close()
+		err := json.NewDecoder(r.Body).Decode(&schema)
+		if err != nil && isBodyTooLarge(err) {
+			HTTPError("ERROR", err.Error(), http.StatusRequestEntityTooLarge, w, r)
+			return
+		}
+		if err != nil && err != io.EOF {
+			msg := fmt.Sprintf("unable to parse schema from request body, error %v", err)
+			HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+			return
+		}
+	}
+	if err := dist.validate(); err != nil {
+		HTTPError("ERROR", err.Error(), http.StatusBadRequest, w, r)
+		return
+	}
+	// use local random source to produce reproducible latency and records for given seed
+	rnd := rand.New(rand.NewSource(seed))
+	latency = dist.sample(rnd, latency)
+	// add uniformly distributed random delay in [0, jitter] range
+	if jitter > 0 {
+		latency += time.Duration(rnd.Int63n(int64(jitter) + 1))
+	}
+	if err := sleepContext(r.Context(), latency); err != nil {
+		handleTimeout(w, r, err, fmt.Sprintf("latency %v", latency))
+		return
+	}
+	if format == "" {
+		format = Config.DefaultFormat
+	}
+	if format == "" {
+		msg := fmt.Sprintf("missing format parameter, should be one of %s", strings.Join(supportedFormats(), ", "))
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+		return
+	}
+	if !formats[format] {
+		msg := fmt.Sprintf("unsupported format %s, should be one of %s", format, strings.Join(supportedFormats(), ", "))
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+		return
+	}
+	// envelope object can't be represented in line oriented formats like ndjson
+	if envelope && format != "json" {
+		msg := fmt.Sprintf("envelope parameter is supported only for json format, got %s", format)
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+		return
 	}
-	if format != "json" && format != "ndjson" {
-		msg := fmt.Sprintf("unsupported format %s", format)
-		HTTPError("ERROR", msg, w)
+	// short responses declare Content-Length larger than the body, so they
+	// require buffered json output which is the only one with Content-Length
+	if shortBy > 0 && (!buffer || format != "json") {
+		msg := "shortby parameter requires buffered json output, please use format=json and buffer=true"
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
 		return
 	}
+	// chunk boundaries are defined by chunked transfer encoding, so they can't
+	// be controlled for buffered responses with Content-Length, and throttled
+	// responses are split into chunks by bandwidth
+	if chunkSize > 0 && buffer {
+		msg := "chunksize parameter is supported only for streamed responses, please drop buffer parameter"
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+		return
+	}
+	if chunkSize > 0 && bps > 0 {
+		msg := "chunksize and bps parameters are mutually exclusive, please provide only one of them"
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+		return
+	}
+	// trailers are sent after the body of chunked responses, so they are
+	// supported only by streamed ndjson whose record count is known at the end
+	if trailers && format != "ndjson" {
+		msg := fmt.Sprintf("trailers parameter is supported only for ndjson format, got %s", format)
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+		return
+	}
+	// buffered payloads declare Content-Length, truncation applies only to
+	// streamed ones which fail mid-stream as real interrupted transfers do
+	if truncateAt > 0 && buffer {
+		msg := "truncateat parameter is supported only for streamed responses, please drop buffer parameter"
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+		return
+	}
+	if count >= 0 && size != "" {
+		msg := "count and size parameters are mutually exclusive, please provide only one of them"
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+		return
+	}
+	var target int64
+	if count < 0 {
+		v, err := parseSize(size)
+		if err != nil {
+			msg := fmt.Sprintf("unable to parse size, error %v", err)
+			HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+			return
+		}
+		target = v
+	}
 
-	records, err := genRecords(size)
-	if err != nil {
-		msg := fmt.Sprintf("unable to generate records, error %v", err)
-		HTTPError("ERROR", msg, w)
+	opts := genOptions{
+		Fill:            fill,
+		Rand:            rnd,
+		Schema:          schema,
+		DataSize:        recordSize,
+		Compressibility: compressibility,
+		Depth:           depth,
+		IDField:         idField,
+		IDStart:         idStart,
+		IDType:          idType,
+		Workers:         Config.GenWorkers,
+		Template:        recordTemplate,
+		Timestamp:       timestamp,
+		TimestampMode:   timestampMode,
+		TimestampStart:  time.Now().UTC().Truncate(time.Second),
+	}
+	// seeded output is reproducible, so timestamps start at fixed time
+	if seeded {
+		opts.TimestampStart = time.Unix(minTimestamp, 0).UTC()
+	}
+	if err := opts.validate(); err != nil {
+		msg := fmt.Sprintf("invalid generator options, error %v", err)
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
 		return
 	}
-	if format == "json" {
-		data, err := json.Marshal(records)
-		if err == nil {
-			w.Write(data)
+	if format == "xml" {
+		if err := validateXMLNames(opts); err != nil {
+			msg := fmt.Sprintf("invalid generator options, error %v", err)
+			HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+			return
+		}
+	}
+	// max size is validated on startup, empty value means unlimited size,
+	// in count mode the size is estimated from number and size of records
+	if maxSize, err := parseSize(Config.MaxSize); Config.MaxSize != "" && err == nil {
+		if count < 0 && target > maxSize {
+			msg := fmt.Sprintf("requested size %s exceeds maximum payload size %s", size, Config.MaxSize)
+			HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
 			return
 		}
-		msg := fmt.Sprintf("unable to marshal records, error %v", err)
-		HTTPError("ERROR", msg, w)
+		if estimate := estimateGenBytes(count, 0, opts); count >= 0 && estimate > maxSize {
+			msg := fmt.Sprintf("requested %d records of estimated size %d bytes exceed maximum payload size %s", count, estimate, Config.MaxSize)
+			HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+			return
+		}
+	}
+	if format == "avro" {
+		if err := avroCompatible(opts); err != nil {
+			HTTPError("ERROR", err.Error(), http.StatusBadRequest, w, r)
+			return
+		}
+	}
+	// inject random failure with given probability, errorrate=0 disables it,
+	// the roll happens after latency sleep to exercise client timeouts as well
+	if errorRate > 0 && opts.Rand.Float64() < errorRate {
+		msg := fmt.Sprintf("injected error with errorrate %v", errorRate)
+		HTTPError("ERROR", msg, http.StatusInternalServerError, w, r)
 		return
-	} else if format == "ndjson" {
-		for _, rec := range records {
-			data, err := json.Marshal(rec)
-			if err != nil {
-				msg := fmt.Sprintf("unable to marshal records, error %v", err)
-				HTTPError("ERROR", msg, w)
-				return
+	}
+	// seeded responses are deterministic, so they can be validated by ETag,
+	// the envelope is excluded since it contains generation timestamp
+	var etag string
+	if seeded && !envelope {
+		etag = payloadETag(r, schema)
+		w.Header().Set("ETag", etag)
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	// rendered payloads of deterministic requests are cached by their ETag,
+	// stack fill and per record delay make output or its timing non-repeatable
+	var cacheKey string
+	if fill != "stack" && recordDelay == 0 && truncateAt == 0 && chunkSize == 0 && shortBy == 0 && !trailers {
+		cacheKey = payloadCache.key(etag, estimateGenBytes(count, target, opts))
+	}
+	cached, hit := payloadCache.get(cacheKey)
+	// wait for generation budget, cached payloads are not generated
+	if !hit && genBudgetLimiter != nil {
+		estimate := estimateGenBytes(count, target, opts)
+		ctx, cancel := context.WithTimeout(r.Context(), genBudgetTimeout)
+		err := genBudgetLimiter.acquire(ctx, estimate)
+		cancel()
+		if err != nil && r.Context().Err() == nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(genBudgetTimeout.Seconds()))))
+			msg := fmt.Sprintf("generation budget of %d bytes is exhausted, unable to generate %d bytes within %v", Config.MaxTotalGenBytes, estimate, genBudgetTimeout)
+			HTTPError("ERROR", msg, http.StatusServiceUnavailable, w, r)
+			return
+		} else if err != nil {
+			handleTimeout(w, r, r.Context().Err(), "waiting for generation budget")
+			return
+		}
+		defer genBudgetLimiter.release(estimate)
+	}
+	gen := newRecordGenerator(count, target, opts)
+	defer gen.close()
+	// records of streamed formats are generated lazily while streaming, it allows
+	// to stop early when client disconnects instead of building GB payloads in memory,
+	// buffered json is marshaled at once and has to be generated in advance
+	var records []Record
+	if !hit && (!lazyFormats[format] || format == "json" && buffer && !envelope) {
+		var err error
+		records, err = gen.all()
+		if err != nil {
+			msg := fmt.Sprintf("unable to generate records, error %v", err)
+			HTTPError("ERROR", msg, http.StatusInternalServerError, w, r)
+			return
+		}
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	// explicitly requested compression takes precedence over negotiated one
+	encoding := compress
+	if encoding == "" && acceptsGzip(r) {
+		encoding = "gzip"
+	}
+	// extra headers from configuration can be overwritten by request ones
+	for k, v := range Config.ExtraHeaders {
+		w.Header().Set(k, v)
+	}
+	for k, v := range headers {
+		w.Header().Set(k, v)
+	}
+	// server closes connection after the response, so clients have to
+	// open fresh connection for the next request
+	if closeConn {
+		w.Header().Set("Connection", "close")
+	}
+	// ask browsers to save payload as a file rather than to display it
+	if download != "" {
+		fname := downloadFilename(download, format)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fname))
+	}
+	// throttle response to given bytes per second, note that total response
+	// time grows linearly with the size, e.g. 10MB at bps=1000000 takes ~10s
+	if r.Method == "HEAD" {
+		// render body as for GET request to declare the same Content-Length,
+		// the body is discarded and sent length is declared on handler return
+		// after compressor is closed, buffered responses declare it themselves,
+		// e.g. with shortby it is larger than written data
+		cw := &countingWriter{ResponseWriter: w}
+		defer func() {
+			if cw.Header().Get("Content-Length") == "" {
+				cw.Header().Set("Content-Length", strconv.FormatInt(cw.written, 10))
 			}
-			w.Write(data)
-			w.Write([]byte("\n"))
+		}()
+		w = cw
+	} else if bps > 0 {
+		w = &throttledWriter{ResponseWriter: w, bps: bps}
+	}
+	// truncateat limits bytes sent over the wire, i.e. after compression
+	var tw *truncatingWriter
+	if truncateAt > 0 && r.Method != "HEAD" {
+		tw = &truncatingWriter{ResponseWriter: w, limit: truncateAt}
+		w = tw
+	}
+	// chunks are made of compressed data, the last chunk is written on
+	// handler return after compressor is closed
+	if chunkSize > 0 && r.Method != "HEAD" {
+		chw := &chunkedWriter{ResponseWriter: w, buf: make([]byte, 0, chunkSize), size: chunkSize}
+		defer chw.finish()
+		w = chw
+	}
+	// content type may be overridden to test content sniffing of clients,
+	// the body is still encoded in requested format
+	if mimeType != "" {
+		w.Header().Set("Content-Type", mimeType)
+	} else {
+		w.Header().Set("Content-Type", contentType(format, separator))
+	}
+	if format == "sse" {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	// trailer has to be announced before the body is written
+	if trailers && r.Method != "HEAD" {
+		w.Header().Set("Trailer", "X-Record-Count")
+	}
+	if hit {
+		writeBuffered(w, r, cached, encoding, 0)
+		return
+	}
+	// helper function to write payload in requested format
+	writePayload := func(w io.Writer) error {
+		switch format {
+		case "json":
+			if envelope {
+				return writeEnvelope(w, gen, indent)
+			}
+			return writeJSON(w, gen, indent)
+		case "ndjson":
+			return writeNDJSON(r.Context(), w, gen, recordDelay, recordSeparators[separator])
+		case "sse":
+			return writeSSE(r.Context(), w, gen, recordDelay)
+		case "csv":
+			return writeCSV(w, records)
+		case "xml":
+			return writeXML(w, records)
+		case "msgpack":
+			return writeMsgpack(r.Context(), w, gen, recordDelay)
+		case "bson":
+			return writeBSON(r.Context(), w, gen, recordDelay)
+		case "lengthprefixed":
+			return writeLengthPrefixed(r.Context(), w, gen, recordDelay)
+		case "avro":
+			return writeAvro(r.Context(), w, gen, avroSyncMarker(seed))
+		}
+		return fmt.Errorf("unsupported format %s", format)
+	}
+	// json output is streamed by default, with buffer=true it is marshaled
+	// in memory first to declare Content-Length for clients which require it,
+	// cached payloads are rendered in memory as well
+	if (format == "json" && buffer) || cacheKey != "" {
+		var data []byte
+		var err error
+		if format == "json" && buffer && !envelope && indent {
+			data, err = json.MarshalIndent(records, "", "  ")
+		} else if format == "json" && buffer && !envelope {
+			data, err = json.Marshal(records)
+		} else {
+			var buf bytes.Buffer
+			err = writePayload(&buf)
+			data = buf.Bytes()
+		}
+		if err != nil {
+			msg := fmt.Sprintf("unable to render %s records, error %v", format, err)
+			HTTPError("ERROR", msg, http.StatusInternalServerError, w, r)
+			return
 		}
+		payloadCache.add(cacheKey, data)
+		writeBuffered(w, r, data, encoding, shortBy)
+		return
+	}
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+		cw := compressors[encoding](w)
+		// close compressor on all code paths to flush remaining bytes
+		defer cw.Close()
+		w = compressResponseWriter{ResponseWriter: w, cw: cw}
+	}
+	if err := writePayload(w); errors.Is(err, errTruncated) {
+		msg := fmt.Sprintf("%s stream to %s truncated at %d bytes after producing %d records", format, r.RemoteAddr, tw.written, gen.id)
+		logMessage("INFO", msg, r, 0)
+		abortConnection(tw.ResponseWriter)
+	} else if err != nil {
+		logWriteError(r, format, err, gen.id)
+	} else if trailers {
+		w.Header().Set("X-Record-Count", strconv.Itoa(gen.id))
 	}
 }
 
 // RequestHandler handles incoming HTTP request
 func RequestHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println(r.Method, r.URL, r.Proto, r.Host, r.RemoteAddr, r.Header)
+	if logEnabled(levelInfo) {
+		msg := fmt.Sprint(r.Method, " ", r.URL, " ", r.Proto, " ", r.Host, " ", r.RemoteAddr, " ", r.Header)
+		logMessage("INFO", msg, r, 0)
+	}
 	if r.Method == "GET" {
-		// print out all request headers
-		fmt.Fprintf(w, "%s %s %s \n", r.Method, r.URL, r.Proto)
-		for k, v := range r.Header {
-			h := strings.ToLower(k)
-			if strings.Contains(h, "hmac") || strings.Contains(h, "cookie") {
-				continue
+		if !Config.HideRootHeaders {
+			// print out all request headers
+			fmt.Fprintf(w, "%s %s %s \n", r.Method, r.URL, r.Proto)
+			for k, v := range r.Header {
+				h := strings.ToLower(k)
+				if strings.Contains(h, "hmac") || strings.Contains(h, "cookie") {
+					continue
+				}
+				fmt.Fprintf(w, "Header field %q, Value %q\n", k, v)
+			}
+			fmt.Fprintf(w, "Host = %q\n", r.Host)
+			fmt.Fprintf(w, "RemoteAddr= %q\n", r.RemoteAddr)
+			if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+				fmt.Fprintf(w, "ClientSubject = %q\n", r.TLS.VerifiedChains[0][0].Subject.String())
 			}
-			fmt.Fprintf(w, "Header field %q, Value %q\n", k, v)
+			fmt.Fprintf(w, "\n\nFinding value of \"Accept\" %q\n", r.Header["Accept"])
 		}
-		fmt.Fprintf(w, "Host = %q\n", r.Host)
-		fmt.Fprintf(w, "RemoteAddr= %q\n", r.RemoteAddr)
-		fmt.Fprintf(w, "\n\nFinding value of \"Accept\" %q\n", r.Header["Accept"])
 
 		page := "Hello from Go\n"
+		if Config.RootMessage != "" {
+			page = Config.RootMessage
+		}
 		w.Write([]byte(page))
 	} else {
 		requestDump, err := httputil.DumpRequest(r, true)
-		if err != nil {
+		if err != nil && isBodyTooLarge(err) {
+			HTTPError("ERROR", err.Error(), http.StatusRequestEntityTooLarge, w, r)
+		} else if err != nil {
 			fmt.Fprint(w, err.Error())
 		} else {
 			fmt.Fprint(w, string(requestDump))
@@ -172,54 +1110,347 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) {
 
 // SearchHandler handles search selectors POST-ed as JSON
 func SearchHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("SearchHandler")
-	if r.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		HTTPError("ERROR", "requests to /search must use the POST method", w)
-		return
-	}
+	logMessage("INFO", "SearchHandler", r, 0)
 	defer r.Body.Close()
 	var selectors struct{}
 	err := json.NewDecoder(r.Body).Decode(&selectors)
+	if err != nil && isBodyTooLarge(err) {
+		HTTPError("ERROR", err.Error(), http.StatusRequestEntityTooLarge, w, r)
+		return
+	}
 	if err != nil {
-		HTTPError("ERROR", "Cannot decode body of request as JSON", w)
+		HTTPError("ERROR", "Cannot decode body of request as JSON", http.StatusBadRequest, w, r)
 		return
 	}
-	log.Println(selectors)
+	logMessage("INFO", fmt.Sprint(selectors), r, 0)
 	w.WriteHeader(http.StatusOK)
 	return
 }
 
-// helper function to parse the config
-func parseConfig(configFile string) error {
-	if configFile == "" {
-		Config.Port = 8888
-		return nil
+// StatusHandler responds with HTTP status code provided via code parameter,
+// e.g. /status?code=503, informational 1xx codes are sent as interim
+// responses and followed by 200 status code by net/http except for 101
+// which net/http sends as final status without switching protocols, so
+// the client hangs waiting for the new protocol and 101 is rejected
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	val := r.URL.Query().Get("code")
+	code, err := strconv.Atoi(val)
+	if err != nil || code < 100 || code > 599 {
+		msg := fmt.Sprintf("invalid status code %q, should be integer in 100-599 range", val)
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+		return
+	}
+	if code == http.StatusSwitchingProtocols {
+		msg := fmt.Sprintf("unsupported status code %d, protocol switching is not supported", code)
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+		return
+	}
+	w.WriteHeader(code)
+	// responses with these status codes are not allowed to have a body
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		return
+	}
+	fmt.Fprintf(w, "%d %s\n", code, http.StatusText(code))
+}
+
+// EchoHandler returns request body back to the client with the same Content-Type,
+// the body size is bounded by Config.MaxBodyBytes
+func EchoHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	data, err := io.ReadAll(r.Body)
+	if err != nil && isBodyTooLarge(err) {
+		HTTPError("ERROR", err.Error(), http.StatusRequestEntityTooLarge, w, r)
+		return
 	}
-	data, err := ioutil.ReadFile(configFile)
 	if err != nil {
-		fmt.Println(err)
-		return err
+		msg := fmt.Sprintf("unable to read request body, error %v", err)
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+		return
+	}
+	if ctype := r.Header.Get("Content-Type"); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
 	}
-	err = json.Unmarshal(data, &Config)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// QueryEchoHandler returns parsed query parameters as JSON object mapping
+// keys to arrays of their values, repeated keys keep all values in order,
+// e.g. /queryecho?a=1&a=2&b= returns {"a":["1","2"],"b":[""]}
+func QueryEchoHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(r.URL.Query())
 	if err != nil {
-		fmt.Println(err)
-		return err
+		msg := fmt.Sprintf("unable to marshal query parameters, error %v", err)
+		HTTPError("ERROR", msg, http.StatusInternalServerError, w, r)
+		return
 	}
-	return nil
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// validationResult represents result of request body validation
+type validationResult struct {
+	Valid  bool   `json:"valid"`
+	Error  string `json:"error,omitempty"`
+	Offset int64  `json:"offset,omitempty"` // offset of syntax error in the body
+}
+
+// ValidateHandler checks that body of POST request is well-formed JSON,
+// it responds with {"valid":true} or with 400 status code and the parse
+// error, the body size is bounded by Config.MaxBodyBytes
+func ValidateHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	data, err := io.ReadAll(r.Body)
+	if err != nil && isBodyTooLarge(err) {
+		HTTPError("ERROR", err.Error(), http.StatusRequestEntityTooLarge, w, r)
+		return
+	}
+	if err != nil {
+		msg := fmt.Sprintf("unable to read request body, error %v", err)
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+		return
+	}
+	result := validationResult{Valid: true}
+	status := http.StatusOK
+	var body interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		result = validationResult{Error: err.Error()}
+		if serr, ok := err.(*json.SyntaxError); ok {
+			result.Offset = serr.Offset
+		}
+		status = http.StatusBadRequest
+	}
+	out, err := json.Marshal(result)
+	if err != nil {
+		msg := fmt.Sprintf("unable to marshal validation result, error %v", err)
+		HTTPError("ERROR", msg, http.StatusInternalServerError, w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// helper function to handle interrupted request, requests exceeding
+// Config.HandlerTimeout get 503 status code while requests canceled by
+// clients are only logged since there is nobody to respond to
+func handleTimeout(w http.ResponseWriter, r *http.Request, err error, action string) {
+	if err == context.DeadlineExceeded {
+		msg := fmt.Sprintf("request exceeded handler timeout %s during %s", Config.HandlerTimeout, action)
+		HTTPError("ERROR", msg, http.StatusServiceUnavailable, w, r)
+		return
+	}
+	logMessage("INFO", fmt.Sprintf("client canceled request during %s", action), r, 0)
+}
+
+// SleepHandler sleeps for the duration provided via duration parameter and
+// responds with 200 status code, e.g. /sleep?duration=2s, it returns early
+// if client cancels the request, the duration is bounded by maxLatency
+func SleepHandler(w http.ResponseWriter, r *http.Request) {
+	val := r.URL.Query().Get("duration")
+	duration, err := time.ParseDuration(val)
+	if err != nil || duration < 0 || duration > maxLatency {
+		msg := fmt.Sprintf("invalid duration value %q, should be duration in 0-%v range, e.g. 2s", val, maxLatency)
+		HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+		return
+	}
+	if err := sleepContext(r.Context(), duration); err != nil {
+		handleTimeout(w, r, err, fmt.Sprintf("sleep %v", duration))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "slept %v\n", duration)
+}
+
+// HealthHandler provides liveness status of the server
+// it does not log requests since health checks are frequent
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	rec := make(Record)
+	rec["status"] = "ok"
+	rec["uptime"] = time.Since(StartTime).String()
+	rec["version"] = info()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		msg := fmt.Sprintf("unable to marshal health status, error %v", err)
+		HTTPError("ERROR", msg, http.StatusInternalServerError, w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// helper function to wrap server multiplexer with middlewares, the
+// middlewares are applied from the innermost to the outermost one
+func serverHandler(handlerTimeout time.Duration) http.Handler {
+	var h http.Handler = serverMux()
+	h = timeoutMiddleware(handlerTimeout)(h)
+	h = bodyLimitMiddleware(h)
+	h = basicAuthMiddleware(h)
+	h = rateLimiter(Config.RateLimit, Config.RateBurst)(h)
+	h = corsMiddleware(h)
+	h = logMiddleware(h)
+	h = servedByMiddleware(h)
+	return requestIDMiddleware(h)
+}
+
+// helper function to create server multiplexer with all endpoints
+// registered under configured base path
+func serverMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	base := basePath()
+	// handlers registered with methods respond with 405 to other methods
+	handle := func(path string, h http.HandlerFunc, methods ...string) {
+		if len(methods) > 0 {
+			h = allowMethods(h, methods...)
+		}
+		mux.HandleFunc(base+path, h)
+	}
+	// payload endpoints share the same concurrency limit
+	limit := concurrencyLimiter(Config.MaxConcurrent)
+	// keep legacy payload endpoint when server runs without base path
+	if base == "" {
+		handle("/httpgo/payload", instrument("/httpgo/payload", limit(PayloadHandler)), "GET", "HEAD", "POST")
+	}
+	handle("/payload", instrument(base+"/payload", limit(PayloadHandler)), "GET", "HEAD", "POST")
+	// RequestHandler dumps requests of any method
+	handle("/", instrument(base+"/", RequestHandler))
+
+	handle("/search", SearchHandler, "POST")
+	handle("/health", HealthHandler, "GET", "HEAD")
+	handle("/status", StatusHandler, "GET", "HEAD", "POST")
+	// EchoHandler echoes requests of any method
+	handle("/echo", EchoHandler)
+	handle("/queryecho", QueryEchoHandler, "GET", "HEAD", "POST")
+	handle("/sleep", SleepHandler, "GET", "HEAD")
+	handle("/validate", ValidateHandler, "POST")
+	handle("/version", VersionHandler, "GET", "HEAD")
+	handle("/ready", ReadyHandler, "GET", "HEAD")
+	handle("/drain", DrainHandler, "POST")
+	handle("/metrics", MetricsHandler, "GET", "HEAD")
+	handle("/stats", StatsHandler, "GET", "HEAD")
+	handle("/admin/loglevel", LogLevelHandler, "GET", "HEAD", "POST")
+	if Config.ShutdownToken != "" {
+		handle("/admin/shutdown", ShutdownHandler, "POST")
+	}
+	// profiling endpoints expose internals of the server, e.g. stack traces
+	// and memory contents, they are disabled by default and when enabled
+	// should be firewalled from untrusted clients
+	if Config.EnablePprof {
+		// pprof.Index resolves profiles by /debug/pprof/ prefix of the path
+		mux.Handle(base+"/debug/pprof/", http.StripPrefix(base, http.HandlerFunc(pprof.Index)))
+		handle("/debug/pprof/cmdline", pprof.Cmdline)
+		handle("/debug/pprof/profile", pprof.Profile)
+		handle("/debug/pprof/symbol", pprof.Symbol)
+		handle("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
+
+// ReadyHandler provides readiness status of the server, it returns 200 when
+// server is ready to accept traffic and 503 when server is draining
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isReady() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"draining"}`))
+		return
+	}
+	if isWarming() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"warming up"}`))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"ready"}`))
+}
+
+// DrainHandler marks server as draining, i.e. not ready, so load balancer
+// stops sending traffic to it before shutdown
+func DrainHandler(w http.ResponseWriter, r *http.Request) {
+	setReady(false)
+	logMessage("INFO", "server is draining", r, 0)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"draining"}`))
+}
+
+// shutdownRequest notifies main goroutine to shutdown the server
+var shutdownRequest = make(chan struct{}, 1)
+
+// ShutdownHandler triggers graceful shutdown of the server as SIGTERM does,
+// the request should provide token parameter matching Config.ShutdownToken
+// to not be hit accidentally, e.g.
+// curl -X POST "http://localhost:8888/admin/shutdown?token=secret"
+// the server is marked as draining and in-flight requests are completed
+// within shutdown timeout, the endpoint is enabled only when token is configured
+func ShutdownHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(Config.ShutdownToken)) != 1 {
+		HTTPError("ERROR", "invalid shutdown token", http.StatusForbidden, w, r)
+		return
+	}
+	setReady(false)
+	logMessage("INFO", "server shutdown is requested", r, 0)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(`{"status":"shutting down"}`))
+	// server waits for this request to complete during shutdown
+	select {
+	case shutdownRequest <- struct{}{}:
+	default:
+	}
+}
+
+// BuildInfo represents build information of the server
+type BuildInfo struct {
+	GitCommit string `json:"git"`
+	GoVersion string `json:"go"`
+	BuildDate string `json:"date"`
+	Uptime    string `json:"uptime,omitempty"`
+}
+
+// String returns human readable representation of build information
+func (b BuildInfo) String() string {
+	return fmt.Sprintf("httpgo git=%s go=%s date=%s", b.GitCommit, b.GoVersion, b.BuildDate)
+}
+
+// helper function to return build information of the server
+func buildInfo() BuildInfo {
+	binfo := BuildInfo{
+		GitCommit: version,
+		GoVersion: runtime.Version(),
+		BuildDate: time.Now().Format("2006-01-02"),
+	}
+	if !StartTime.IsZero() {
+		binfo.Uptime = time.Since(StartTime).String()
+	}
+	return binfo
 }
 
 // helper function to return version string of the server
 func info() string {
-	goVersion := runtime.Version()
-	tstamp := time.Now().Format("2006-01-02")
-	return fmt.Sprintf("httpgo git=%s go=%s date=%s", version, goVersion, tstamp)
+	return buildInfo().String()
+}
+
+// VersionHandler provides build information of the server as JSON
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(buildInfo())
+	if err != nil {
+		msg := fmt.Sprintf("unable to marshal build info, error %v", err)
+		HTTPError("ERROR", msg, http.StatusInternalServerError, w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
 }
 
 // main function
 func main() {
-	var config string
-	flag.StringVar(&config, "config", "", "configuration file")
+	var config configFiles
+	flag.Var(&config, "config", "configuration file, use - to read it from stdin, repeat the flag to merge files in order")
 	var version bool
 	flag.BoolVar(&version, "version", false, "print version information about the server")
 	flag.Parse()
@@ -227,6 +1458,8 @@ func main() {
 		fmt.Println(info())
 		os.Exit(0)
 	}
+	StartTime = time.Now()
+	rand.Seed(StartTime.UnixNano())
 	// log time, filename, and line number
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
@@ -234,25 +1467,172 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	http.HandleFunc("/httpgo/payload", PayloadHandler)
-	http.HandleFunc("/payload", PayloadHandler)
-	http.HandleFunc("/", RequestHandler)
-
-	http.HandleFunc("/search", SearchHandler)
+	err = applyEnvOverrides()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = Config.Validate()
+	if err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+	logConfig(Config)
 
-	if Config.ServerKey != "" && Config.ServerCrt != "" {
-		server := &http.Server{
-			Addr: fmt.Sprintf(":%d", Config.Port),
-			TLSConfig: &tls.Config{
-				InsecureSkipVerify: true,
-				//             ClientAuth: tls.RequestClientCert,
-			},
+	if Config.ServedBy {
+		hostname, err = os.Hostname()
+		if err != nil {
+			log.Fatal("unable to resolve host name: ", err)
 		}
-		err = server.ListenAndServeTLS(Config.ServerCrt, Config.ServerKey)
+	}
+	if Config.CacheSize != "" {
+		size, err := parseSize(Config.CacheSize)
 		if err != nil {
-			fmt.Println("Unable to start the server", err)
+			log.Fatal("invalid cache size: ", err)
 		}
+		payloadCache = newLRUCache(size)
+	}
+	if Config.RecordTemplate != "" {
+		recordTemplate, err = loadRecordTemplate(Config.RecordTemplate)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if Config.MaxTotalGenBytes > 0 {
+		genBudgetLimiter = newGenBudget(Config.MaxTotalGenBytes)
+		genBudgetTimeout, err = parseDuration("genbudgettimeout", Config.GenBudgetTimeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if genBudgetTimeout == 0 {
+			genBudgetTimeout = defaultGenBudgetTimeout
+		}
+	}
+	handlerTimeout, err := parseDuration("handlertimeout", Config.HandlerTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler := serverHandler(handlerTimeout)
+	// TLS and port settings are ignored when server listens on unix socket
+	useTLS := Config.UnixSocket == "" && Config.ServerKey != "" && Config.ServerCrt != ""
+	var tlsConfig *tls.Config
+	if useTLS {
+		tlsConfig, err = serverTLSConfig()
+		if err != nil {
+			log.Fatal("unable to create TLS configuration: ", err)
+		}
+	}
+	if !useTLS && Config.EnableH2C {
+		// allow clients to use HTTP/2 with prior knowledge over cleartext,
+		// HTTP/1.1 clients are served as usual
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	// every port is served by its own server sharing the same handler
+	var addrs []string
+	if Config.UnixSocket != "" {
+		addrs = []string{Config.UnixSocket}
 	} else {
-		http.ListenAndServe(fmt.Sprintf(":%d", Config.Port), nil)
+		for _, port := range Config.listenPorts() {
+			addrs = append(addrs, net.JoinHostPort(Config.Host, strconv.Itoa(port)))
+		}
+	}
+	var servers []*http.Server
+	for _, addr := range addrs {
+		server := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+		if err := setServerTimeouts(server); err != nil {
+			log.Fatal("unable to set server timeouts: ", err)
+		}
+		if Config.DisableKeepAlives {
+			server.SetKeepAlivesEnabled(false)
+		}
+		servers = append(servers, server)
+	}
+	// delay start of the server to exercise startup probes of orchestrators
+	delay, err := parseDuration("startupdelay", Config.StartupDelay)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if delay > 0 {
+		logMessage("INFO", fmt.Sprintf("delay server startup by %v", delay), nil, 0)
+		time.Sleep(delay)
+	}
+	// servers without listener listen on their address by themselves
+	listeners := make(map[*http.Server]net.Listener)
+	if Config.UnixSocket != "" {
+		// remove stale socket file left by previous run
+		if err := os.Remove(Config.UnixSocket); err != nil && !os.IsNotExist(err) {
+			log.Fatal("unable to remove stale unix socket: ", err)
+		}
+		listener, err := net.Listen("unix", Config.UnixSocket)
+		if err != nil {
+			log.Fatal("unable to listen on unix socket: ", err)
+		}
+		listeners[servers[0]] = listener
+		logMessage("INFO", fmt.Sprintf("listening on unix socket %s", Config.UnixSocket), nil, 0)
+	} else if Config.ReusePort {
+		for _, server := range servers {
+			listener, err := listenReusePort(server.Addr)
+			if err != nil {
+				log.Fatal("unable to listen with SO_REUSEPORT: ", err)
+			}
+			listeners[server] = listener
+		}
+	}
+	if len(Config.WarmupRequests) > 0 {
+		setWarming(true)
+		go warmupCache(Config.WarmupRequests)
+	}
+	for _, server := range servers {
+		go func(server *http.Server, listener net.Listener) {
+			var err error
+			if listener != nil && useTLS {
+				err = server.ServeTLS(listener, "", "")
+			} else if listener != nil {
+				err = server.Serve(listener)
+			} else if useTLS {
+				// certificate is provided by GetCertificate of TLS configuration
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatal("Unable to start the server ", err)
+			}
+		}(server, listeners[server])
+	}
+
+	// wait for termination signal and gracefully shutdown the server
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	var reason string
+	select {
+	case s := <-sig:
+		reason = fmt.Sprintf("received %v signal", s)
+	case <-shutdownRequest:
+		reason = "received shutdown request"
+	}
+	setReady(false)
+	timeout := 10 * time.Second
+	if Config.ShutdownTimeout > 0 {
+		timeout = time.Duration(Config.ShutdownTimeout) * time.Second
+	}
+	logMessage("INFO", fmt.Sprintf("%s, shutting down the server with timeout %v", reason, timeout), nil, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// servers are shut down concurrently to share the shutdown timeout
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				logMessage("ERROR", fmt.Sprintf("unable to gracefully shutdown the server on %s, error %v", server.Addr, err), nil, 0)
+			}
+		}(server)
+	}
+	wg.Wait()
+	if Config.UnixSocket != "" {
+		if err := os.Remove(Config.UnixSocket); err != nil && !os.IsNotExist(err) {
+			logMessage("ERROR", fmt.Sprintf("unable to remove unix socket, error %v", err), nil, 0)
+		}
 	}
+	logMessage("INFO", "server shutdown is complete", nil, 0)
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// maxLatency defines upper limit of latency accepted by PayloadHandler
+const maxLatency = 10 * time.Minute
+
+// helper function to sleep for given duration, it returns early with
+// context error when given context is done, e.g. handler timeout is reached
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// helper function to parse latency value, it accepts either bare integer
+// (interpreted as seconds) or duration string, e.g. 250ms, 1.5s, 2m
+func parseLatency(val string) (time.Duration, error) {
+	var latency time.Duration
+	if v, err := strconv.Atoi(val); err == nil {
+		// reject large values before conversion which may overflow duration
+		if v > int(maxLatency/time.Second) {
+			return 0, fmt.Errorf("latency %q is out of range, should be between 0 and %v", val, maxLatency)
+		}
+		latency = time.Duration(v) * time.Second
+	} else {
+		latency, err = time.ParseDuration(val)
+		if err != nil {
+			return 0, fmt.Errorf("invalid latency %q, should be integer seconds or duration like 250ms, 1.5s, 2m", val)
+		}
+	}
+	if latency < 0 || latency > maxLatency {
+		return 0, fmt.Errorf("latency %q is out of range, should be between 0 and %v", val, maxLatency)
+	}
+	return latency, nil
+}
+
+// latencyDist represents distribution of payload latency, supported
+// distributions and their parameters are:
+// - constant: fixed latency
+// - uniform: latency uniformly distributed in [min, max] range
+// - exponential: exponentially distributed latency with given mean
+// - normal: normally distributed latency with given mean and stddev
+type latencyDist struct {
+	Name   string
+	Mean   time.Duration
+	Stddev time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+// helper function to validate latency distribution
+func (d latencyDist) validate() error {
+	switch d.Name {
+	case "", "constant", "exponential", "normal":
+		return nil
+	case "uniform":
+		if d.Min > d.Max {
+			return fmt.Errorf("min latency %v is larger than max latency %v", d.Min, d.Max)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported latency distribution %q, should be constant, uniform, exponential or normal", d.Name)
+}
+
+// helper function to sample latency from the distribution using given random
+// source, the constant distribution returns provided latency as is, and
+// for other distributions the sample is bounded by [0, maxLatency] range
+func (d latencyDist) sample(rnd *rand.Rand, latency time.Duration) time.Duration {
+	var v float64
+	switch d.Name {
+	case "uniform":
+		v = float64(d.Min) + rnd.Float64()*float64(d.Max-d.Min)
+	case "exponential":
+		v = rnd.ExpFloat64() * float64(d.Mean)
+	case "normal":
+		v = rnd.NormFloat64()*float64(d.Stddev) + float64(d.Mean)
+	default:
+		return latency
+	}
+	v = math.Max(0, math.Min(v, float64(maxLatency)))
+	return time.Duration(v)
+}
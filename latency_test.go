@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseLatency tests parsing of latency values and their range checks
+func TestParseLatency(t *testing.T) {
+	tests := []struct {
+		val     string
+		latency time.Duration
+		valid   bool
+	}{
+		{"2", 2 * time.Second, true},
+		{"250ms", 250 * time.Millisecond, true},
+		{"600", maxLatency, true},
+		{"601", 0, false},
+		{"-1", 0, false},
+		{"18446744074", 0, false},
+		{"11m", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		latency, err := parseLatency(tt.val)
+		if (err == nil) != tt.valid {
+			t.Errorf("parseLatency(%q) error %v, expected valid=%v", tt.val, err, tt.valid)
+		} else if latency != tt.latency {
+			t.Errorf("parseLatency(%q) = %v, expected %v", tt.val, latency, tt.latency)
+		}
+	}
+}
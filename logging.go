@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// log levels of request logging, the zero value is info level
+const (
+	levelDebug int32 = iota - 1 // all requests are logged including health checks
+	levelInfo                   // requests are logged except health checks
+	levelWarn                   // requests are not logged, only warnings and errors
+)
+
+// logLevelNames defines names of log levels accepted by LogLevelHandler
+var logLevelNames = map[string]int32{"debug": levelDebug, "info": levelInfo, "warn": levelWarn}
+
+// logLevel holds current log level, it can be changed at runtime via
+// /admin/loglevel endpoint
+var logLevel atomic.Int32
+
+// helper function to check if messages of given level are logged
+func logEnabled(level int32) bool {
+	return logLevel.Load() <= level
+}
+
+// helper function to return name of current log level
+func logLevelName() string {
+	level := logLevel.Load()
+	for name, v := range logLevelNames {
+		if v == level {
+			return name
+		}
+	}
+	return "info"
+}
+
+// logEntry represents structured log record emitted when Config.LogFormat is json
+type logEntry struct {
+	Level     string `json:"level"`
+	Time      string `json:"time"`
+	Msg       string `json:"msg"`
+	Method    string `json:"method,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Status    int    `json:"status,omitempty"`
+	Remote    string `json:"remote,omitempty"`
+	Bytes     int64  `json:"bytes,omitempty"`
+	Referer   string `json:"referer,omitempty"`
+	UserAgent string `json:"useragent,omitempty"`
+	RequestID string `json:"requestid,omitempty"`
+
+	Config *Configuration `json:"config,omitempty"`
+}
+
+// jsonLog represents logger of structured log records
+var jsonLog = log.New(os.Stderr, "", 0)
+
+// helper function to check if structured JSON logging is enabled
+func jsonLogging() bool {
+	return Config.LogFormat == "json"
+}
+
+// helper function to write log entry as single line JSON object
+func writeLogEntry(logger *log.Logger, entry logEntry) {
+	entry.Time = time.Now().Format(time.RFC3339Nano)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("ERROR", "unable to marshal log entry", err)
+		return
+	}
+	logger.Println(string(data))
+}
+
+// helper function to log message with given level, e.g. INFO or ERROR,
+// the request (if provided) and status code are only included in JSON records
+func logMessage(level, msg string, r *http.Request, status int) {
+	if !jsonLogging() {
+		// report file and line of the caller
+		log.Output(2, level+" "+msg)
+		return
+	}
+	entry := logEntry{Level: level, Msg: msg, Status: status}
+	if r != nil {
+		entry.Method = r.Method
+		entry.Path = r.URL.Path
+		entry.RequestID = requestID(r)
+	}
+	writeLogEntry(jsonLog, entry)
+}
+
+// LogLevelHandler provides current log level of request logging, POST
+// request with level=debug|info|warn parameter changes it at runtime, e.g.
+// curl -X POST "http://localhost:8888/admin/loglevel?level=debug"
+// like other endpoints it requires basic auth credentials when they are configured
+func LogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		name := r.FormValue("level")
+		level, ok := logLevelNames[name]
+		if !ok {
+			msg := fmt.Sprintf("invalid level value %q, should be debug, info or warn", name)
+			HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+			return
+		}
+		logLevel.Store(level)
+		logMessage("INFO", fmt.Sprintf("log level is set to %s", name), r, 0)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "{\"level\":%q}", logLevelName())
+}
+
+// helper function to log effective configuration of the server, secrets
+// are redacted, in JSON log format configuration is logged as nested object
+func logConfig(c Configuration) {
+	c = c.redacted()
+	if jsonLogging() {
+		writeLogEntry(jsonLog, logEntry{Level: "INFO", Msg: "configuration", Config: &c})
+		return
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		logMessage("ERROR", fmt.Sprintf("unable to marshal configuration, error %v", err), nil, 0)
+		return
+	}
+	log.Output(2, "INFO configuration "+string(data))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets defines upper bounds (in seconds) of request duration histogram,
+// it extends default Prometheus buckets to cover long latency payload requests
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// histogram represents Prometheus histogram of observed values
+type histogram struct {
+	Counts []uint64 // non-cumulative counts per bucket
+	Sum    float64
+	Count  uint64
+}
+
+// helper function to observe given value in histogram
+func (h *histogram) observe(v float64) {
+	for i, b := range durationBuckets {
+		if v <= b {
+			h.Counts[i]++
+			break
+		}
+	}
+	h.Sum += v
+	h.Count++
+}
+
+// Metrics represents server metrics exposed in Prometheus text format
+type Metrics struct {
+	mu        sync.Mutex
+	Requests  map[string]uint64     // total number of requests per path
+	Bytes     map[string]uint64     // total number of response bytes per path
+	Durations map[string]*histogram // response time histograms per path
+}
+
+// metrics holds server metrics
+var metrics = Metrics{
+	Requests:  make(map[string]uint64),
+	Bytes:     make(map[string]uint64),
+	Durations: make(map[string]*histogram),
+}
+
+// helper function to update metrics of given path
+func (m *Metrics) update(path string, bytes int64, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Requests[path]++
+	m.Bytes[path] += uint64(bytes)
+	h, ok := m.Durations[path]
+	if !ok {
+		h = &histogram{Counts: make([]uint64, len(durationBuckets))}
+		m.Durations[path] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// helper function to render metrics in Prometheus text format
+func (m *Metrics) prometheus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var paths []string
+	for p := range m.Requests {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var out strings.Builder
+	out.WriteString("# HELP httpgo_requests_total Total number of HTTP requests\n")
+	out.WriteString("# TYPE httpgo_requests_total counter\n")
+	for _, p := range paths {
+		fmt.Fprintf(&out, "httpgo_requests_total{path=%q} %d\n", p, m.Requests[p])
+	}
+	out.WriteString("# HELP httpgo_response_bytes_total Total number of HTTP response bytes\n")
+	out.WriteString("# TYPE httpgo_response_bytes_total counter\n")
+	for _, p := range paths {
+		fmt.Fprintf(&out, "httpgo_response_bytes_total{path=%q} %d\n", p, m.Bytes[p])
+	}
+	out.WriteString("# HELP httpgo_request_duration_seconds HTTP response time in seconds\n")
+	out.WriteString("# TYPE httpgo_request_duration_seconds histogram\n")
+	for _, p := range paths {
+		h := m.Durations[p]
+		var total uint64
+		for i, b := range durationBuckets {
+			total += h.Counts[i]
+			fmt.Fprintf(&out, "httpgo_request_duration_seconds_bucket{path=%q,le=\"%g\"} %d\n", p, b, total)
+		}
+		fmt.Fprintf(&out, "httpgo_request_duration_seconds_bucket{path=%q,le=\"+Inf\"} %d\n", p, h.Count)
+		fmt.Fprintf(&out, "httpgo_request_duration_seconds_sum{path=%q} %g\n", p, h.Sum)
+		fmt.Fprintf(&out, "httpgo_request_duration_seconds_count{path=%q} %d\n", p, h.Count)
+	}
+	return out.String()
+}
+
+// helper function to instrument given handler with metrics and stats of given path
+func instrument(path string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		h(rw, r)
+		metrics.update(path, rw.bytes, time.Since(start))
+		stats.record(path, rw.bytes, rw.status)
+	}
+}
+
+// MetricsHandler provides server metrics in Prometheus text format
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(metrics.prometheus()))
+}
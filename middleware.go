@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// responseWriter wraps http.ResponseWriter and records status code and
+// number of bytes written to the client
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader implements http.ResponseWriter interface and records status code
+func (w *responseWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter interface and counts written bytes
+func (w *responseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher interface if underlying writer supports it
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns underlying http.ResponseWriter to http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// accessLog represents logger of HTTP requests in Apache combined log format
+var accessLog = log.New(os.Stdout, "", 0)
+
+// helper function to return value of the log field or "-" if it is empty
+func logField(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// logMiddleware logs every HTTP request in Apache combined log format followed
+// by request id, e.g. 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700]
+// "GET /payload HTTP/1.1" 200 2326 "-" "curl/7.64.1" "<request id>"
+// health check requests are logged only at debug level since they are
+// frequent, at warn level requests are not logged
+func logMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rw, r)
+		stats.recordStatus(r.URL.Path, rw.status)
+		if !logEnabled(levelInfo) || (r.URL.Path == basePath()+"/health" && !logEnabled(levelDebug)) {
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		user := "-"
+		if u, _, ok := r.BasicAuth(); ok && u != "" {
+			user = u
+		}
+		if jsonLogging() {
+			writeLogEntry(accessLog, logEntry{
+				Level:     "INFO",
+				Msg:       "access",
+				Method:    r.Method,
+				Path:      r.URL.RequestURI(),
+				Status:    rw.status,
+				Remote:    host,
+				Bytes:     rw.bytes,
+				Referer:   r.Referer(),
+				UserAgent: r.UserAgent(),
+				RequestID: requestID(r),
+			})
+			return
+		}
+		bytes := "-"
+		if rw.bytes > 0 {
+			bytes = strconv.FormatInt(rw.bytes, 10)
+		}
+		request := fmt.Sprintf("%s %s %s", r.Method, r.URL.RequestURI(), r.Proto)
+		accessLog.Printf("%s - %s [%s] %q %d %s %q %q %q\n",
+			host, user, start.Format("02/Jan/2006:15:04:05 -0700"), request,
+			rw.status, bytes, logField(r.Referer()), logField(r.UserAgent()),
+			logField(requestID(r)))
+	})
+}
+
+// helper function to check if error is caused by too large request body
+func isBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// bodyLimitMiddleware limits size of request body to Config.MaxBodyBytes,
+// requests with larger bodies are rejected with 413 status code
+func bodyLimitMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := Config.MaxBodyBytes
+		if limit <= 0 {
+			limit = defaultMaxBodyBytes
+		}
+		if r.ContentLength > limit {
+			msg := fmt.Sprintf("request body of %d bytes exceeds limit of %d bytes", r.ContentLength, limit)
+			HTTPError("ERROR", msg, http.StatusRequestEntityTooLarge, w, r)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// contextKey represents type of keys stored in request context
+type contextKey string
+
+// requestIDKey defines context key of request id
+const requestIDKey = contextKey("requestID")
+
+// helper function to generate random (version 4) UUID
+func uuid() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// helper function to check that client provided request id is safe to
+// log and echo back, i.e. it is short and contains only printable ASCII characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// helper function to return request id stored in request context
+func requestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// requestIDMiddleware assigns unique id to every request, it either reuses
+// X-Request-ID header provided by the client or generates new UUID, the id
+// is stored in request context and returned back via X-Request-ID header
+func requestIDMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = uuid()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// helper function to create middleware which limits number of simultaneous
+// in-flight requests to given limit using buffered channel as semaphore,
+// requests above the limit are rejected with 503 status code and Retry-After header,
+// zero or negative limit means unlimited number of requests
+func concurrencyLimiter(limit int) func(http.HandlerFunc) http.HandlerFunc {
+	if limit <= 0 {
+		return func(h http.HandlerFunc) http.HandlerFunc { return h }
+	}
+	sem := make(chan struct{}, limit)
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				h(w, r)
+			default:
+				w.Header().Set("Retry-After", "1")
+				msg := fmt.Sprintf("too many concurrent requests, limit is %d", limit)
+				HTTPError("ERROR", msg, http.StatusServiceUnavailable, w, r)
+			}
+		}
+	}
+}
+
+// helper function to check if origin is allowed by Config.AllowedOrigins
+func allowedOrigin(origin string) bool {
+	for _, o := range Config.AllowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// corsMiddleware sets CORS headers for requests from origins listed in
+// Config.AllowedOrigins and responds to preflight OPTIONS requests with 204,
+// requests from other origins are served without CORS headers
+func corsMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(Config.AllowedOrigins) == 0 || origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !allowedOrigin(origin) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			headers := r.Header.Get("Access-Control-Request-Headers")
+			if headers == "" {
+				headers = "Content-Type, X-Request-ID"
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, HEAD, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// hostname holds host name of the server, it is resolved on startup
+var hostname string
+
+// helper function to return host name of the server to identify it in
+// responses, it is empty unless Config.ServedBy is set
+func servedBy() string {
+	if !Config.ServedBy {
+		return ""
+	}
+	return hostname
+}
+
+// servedByMiddleware sets X-Served-By header with host name of the server
+// to identify backend instance behind load balancer
+func servedByMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name := servedBy(); name != "" {
+			w.Header().Set("X-Served-By", name)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// timeoutMiddleware returns middleware which sets deadline of request context
+// to given timeout, handlers respond with 503 when deadline is exceeded during
+// latency simulation, streamed responses are cut off since their status code
+// is already sent, non-positive timeout disables the deadline
+func timeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	if timeout <= 0 {
+		return func(h http.Handler) http.Handler { return h }
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// helper function to restrict given handler to given HTTP methods, requests
+// with other methods are rejected with 405 and Allow header listing allowed
+// methods, OPTIONS requests are answered with Allow header as well
+func allowMethods(h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	allow := strings.Join(methods, ", ") + ", OPTIONS"
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range methods {
+			if r.Method == m {
+				h(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", allow)
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		msg := fmt.Sprintf("method %s is not allowed, should be one of %s", r.Method, strings.Join(methods, ", "))
+		HTTPError("ERROR", msg, http.StatusMethodNotAllowed, w, r)
+	}
+}
+
+// helper function to check if basic auth is enabled by configuration
+func basicAuthEnabled() bool {
+	return Config.BasicAuthUser != "" && Config.BasicAuthPass != ""
+}
+
+// basicAuthMiddleware protects all endpoints with HTTP Basic Auth when both
+// Config.BasicAuthUser and Config.BasicAuthPass are set, credentials are
+// compared in constant time to avoid timing leaks
+func basicAuthMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !basicAuthEnabled() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		user, pass, ok := r.BasicAuth()
+		// evaluate both comparisons to not reveal which one has failed
+		userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(Config.BasicAuthUser))
+		passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(Config.BasicAuthPass))
+		if !ok || userMatch&passMatch != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="httpgo", charset="UTF-8"`)
+			HTTPError("ERROR", "unauthorized request", http.StatusUnauthorized, w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
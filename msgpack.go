@@ -0,0 +1,27 @@
+//go:build !nomsgpack
+
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// register msgpack output format, it can be excluded from the build
+// via nomsgpack build tag
+func init() {
+	formats["msgpack"] = true
+}
+
+// helper function to stream records produced lazily by given generator as
+// sequence of MessagePack objects, records are encoded one by one similar to ndjson format
+func writeMsgpack(ctx context.Context, w io.Writer, gen *recordGenerator, delay time.Duration) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetSortMapKeys(true)
+	return streamRecords(ctx, w, gen, delay, func(w io.Writer, seq int, rec Record) error {
+		return enc.Encode(rec)
+	})
+}
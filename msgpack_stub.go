@@ -0,0 +1,16 @@
+//go:build nomsgpack
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// writeMsgpack is not available in builds with nomsgpack tag,
+// the msgpack format is not registered and PayloadHandler rejects it
+func writeMsgpack(ctx context.Context, w io.Writer, gen *recordGenerator, delay time.Duration) error {
+	return errors.New("msgpack format is not supported by this build")
+}
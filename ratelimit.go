@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdleTime defines how long limiter of a client is kept after its last request
+const limiterIdleTime = 3 * time.Minute
+
+// clientLimiter represents rate limiter of a single client
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipRateLimiter keeps token-bucket rate limiters per client IP
+type ipRateLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*clientLimiter
+}
+
+// helper function to return rate limiter of given client IP
+func (l *ipRateLimiter) get(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.limiters[ip]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[ip] = c
+	}
+	c.lastSeen = time.Now()
+	return c.limiter
+}
+
+// helper function to periodically remove limiters of idle clients
+func (l *ipRateLimiter) cleanup() {
+	for range time.Tick(time.Minute) {
+		l.mu.Lock()
+		for ip, c := range l.limiters {
+			if time.Since(c.lastSeen) > limiterIdleTime {
+				delete(l.limiters, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// helper function to extract client IP of the request, the X-Forwarded-For
+// header is used only when Config.TrustProxy is set since clients can forge it
+func clientIP(r *http.Request) string {
+	if Config.TrustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			// the left-most address is the original client
+			ip := strings.TrimSpace(strings.Split(xff, ",")[0])
+			if ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimiter returns middleware which limits requests of every client IP
+// to given number of requests per second with given burst, requests over
+// the limit are rejected with 429, non-positive limit disables rate limiting
+func rateLimiter(limit float64, burst int) func(http.Handler) http.Handler {
+	if limit <= 0 {
+		return func(h http.Handler) http.Handler { return h }
+	}
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(limit)))
+	}
+	l := &ipRateLimiter{
+		limit:    rate.Limit(limit),
+		burst:    burst,
+		limiters: make(map[string]*clientLimiter),
+	}
+	go l.cleanup()
+	// clients may retry once a single token is replenished
+	retry := strconv.Itoa(int(math.Max(1, math.Ceil(1/limit))))
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.get(clientIP(r)).Allow() {
+				w.Header().Set("Retry-After", retry)
+				msg := fmt.Sprintf("too many requests, limit is %g requests per second", limit)
+				HTTPError("ERROR", msg, http.StatusTooManyRequests, w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// Record represent generic record
+type Record map[string]interface{}
+
+// sizeUnits defines multipliers of size units supported by parseSize,
+// KB, MB and GB are decimal (1000-based) while KiB, MiB and GiB are
+// binary (1024-based) multiples, units are matched case insensitively
+var sizeUnits = map[string]int64{
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"KIB": 1024,
+	"MIB": 1024 * 1024,
+	"GIB": 1024 * 1024 * 1024,
+}
+
+// helper function to split size string, e.g. "1.5 MiB", into its number
+// part and multiplier of its unit
+func splitSize(size string) (string, int64, error) {
+	size = strings.TrimSpace(size)
+	idx := strings.IndexFunc(size, unicode.IsLetter)
+	if idx < 0 {
+		return "", 0, errors.New("missing size unit, should be B, KB, MB, GB, KiB, MiB or GiB")
+	}
+	unit := size[idx:]
+	multiplier, ok := sizeUnits[strings.ToUpper(unit)]
+	if !ok {
+		return "", 0, fmt.Errorf("unsupported size unit %q, should be B, KB, MB, GB, KiB, MiB or GiB", unit)
+	}
+	return strings.TrimSpace(size[:idx]), multiplier, nil
+}
+
+// helper function to parse size string, e.g. 5MB, into number of bytes,
+// the size may be fractional, e.g. 1.5MB, surrounding whitespace is ignored
+// and units are case insensitive, e.g. " 5 mb" is the same as "5MB"
+func parseSize(size string) (int64, error) {
+	number, multiplier, err := splitSize(size)
+	if err != nil {
+		return 0, err
+	}
+	total, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, err
+	}
+	bytes := total * float64(multiplier)
+	if math.IsNaN(bytes) || bytes < 0 || bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is out of range", size)
+	}
+	return int64(bytes), nil
+}
+
+// Schema represents structure of generated records as mapping of
+// field names to their types, e.g. {"name":"string","age":"int"}
+type Schema map[string]string
+
+// schemaTypes defines field types supported by Schema
+var schemaTypes = map[string]bool{
+	"int":       true,
+	"string":    true,
+	"bool":      true,
+	"timestamp": true,
+}
+
+// genOptions represents options of the records generator
+type genOptions struct {
+	Fill            string             // strategy to fill data field of records
+	Rand            *rand.Rand         // source of randomness, seeded for reproducible output
+	Schema          Schema             // optional structure of records, default is {id, data}
+	DataSize        int                // size of data field of records, default is dataSize
+	Compressibility float64            // fraction of random data replaced by repeating pattern, 0 is incompressible
+	Depth           int                // number of nesting levels of data field, 0 keeps data flat
+	IDField         string             // name of record id field, default is id
+	IDStart         int                // id of the first record
+	IDType          string             // type of record id, int (default), string or float
+	Workers         int                // number of generation workers, 0 or 1 means sequential generation
+	Timestamp       string             // layout of record timestamp field, rfc3339, unix, unixmilli or Go layout, empty omits the field
+	TimestampMode   string             // increment (default) timestamps by a second per record or randomize them
+	TimestampStart  time.Time          // timestamp of the first record in increment mode
+	Template        *template.Template // optional template rendering records as JSON objects, replaces default {id, data} structure
+}
+
+// maxDepth defines max nesting level of data field of records
+const maxDepth = 100
+
+// helper function to validate generator options
+func (o genOptions) validate() error {
+	for name, typ := range o.Schema {
+		if !schemaTypes[typ] {
+			return fmt.Errorf("unsupported type %q of schema field %q, should be int, string, bool or timestamp", typ, name)
+		}
+	}
+	if o.IDField == "data" {
+		return errors.New("unsupported idfield \"data\", it is reserved for data field")
+	}
+	switch o.IDType {
+	case "", "int", "string", "float":
+	default:
+		return fmt.Errorf("unsupported idtype %q, should be int, string or float", o.IDType)
+	}
+	if o.IDStart < 0 {
+		return fmt.Errorf("unsupported idstart %d, should be non-negative", o.IDStart)
+	}
+	if o.Depth < 0 || o.Depth > maxDepth {
+		return fmt.Errorf("unsupported depth %d, should be in 0-%d range", o.Depth, maxDepth)
+	}
+	if o.Compressibility < 0 || o.Compressibility > 1 {
+		return fmt.Errorf("unsupported compressibility %v, should be in 0-1 range", o.Compressibility)
+	}
+	if o.Timestamp != "" {
+		if err := validateTimestamp(o); err != nil {
+			return err
+		}
+	}
+	switch o.Fill {
+	case "", "random", "zeros", "stack":
+		return nil
+	}
+	return fmt.Errorf("unsupported fill %q, should be random, zeros or stack", o.Fill)
+}
+
+// letters defines alphabet of randomly generated strings
+const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// time range of randomly generated timestamps, it is fixed to keep
+// records reproducible for given seed
+var (
+	minTimestamp = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	maxTimestamp = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+)
+
+// helper function to generate random value of given schema type
+func schemaValue(rnd *rand.Rand, typ string) interface{} {
+	switch typ {
+	case "int":
+		return rnd.Int63()
+	case "bool":
+		return rnd.Intn(2) == 1
+	case "timestamp":
+		sec := minTimestamp + rnd.Int63n(maxTimestamp-minTimestamp)
+		return time.Unix(sec, 0).UTC().Format(time.RFC3339)
+	}
+	buf := make([]byte, 16)
+	for i := range buf {
+		buf[i] = letters[rnd.Intn(len(letters))]
+	}
+	return string(buf)
+}
+
+// dataSize defines default size of the data field of generated records
+const dataSize = 1024
+
+// helper function to generate random bytes of given length
+func randomBytes(rnd *rand.Rand, size int) []byte {
+	data := make([]byte, size)
+	rnd.Read(data)
+	return data
+}
+
+// helper function to generate filler data of the records using given strategy:
+// random (default) for random bytes, zeros for zero bytes and stack for stack trace,
+// the strategy is validated once per request by genOptions.validate
+func recordData(opts genOptions) ([]byte, error) {
+	size := dataSize
+	if opts.DataSize > 0 {
+		size = opts.DataSize
+	}
+	switch opts.Fill {
+	case "zeros":
+		return make([]byte, size), nil
+	case "stack":
+		slice := make([]byte, size)
+		n := runtime.Stack(slice, false)
+		return slice[0:n], nil
+	}
+	data := randomBytes(opts.Rand, size)
+	if opts.Compressibility > 0 {
+		mixPattern(opts.Rand, data, opts.Compressibility)
+	}
+	return data, nil
+}
+
+// patternBlock defines size of data blocks replaced by repeating pattern
+const patternBlock = 64
+
+// helper function to replace blocks of given data by repeating pattern
+// with given probability, it allows to control how well data compresses
+func mixPattern(rnd *rand.Rand, data []byte, probability float64) {
+	for start := 0; start < len(data); start += patternBlock {
+		if rnd.Float64() >= probability {
+			continue
+		}
+		end := start + patternBlock
+		if end > len(data) {
+			end = len(data)
+		}
+		for i := start; i < end; i++ {
+			data[i] = letters[i%len(letters)]
+		}
+	}
+}
+
+// recordTemplate holds template of records loaded from Config.RecordTemplate
+var recordTemplate *template.Template
+
+// templateRecord represents data available to record template, e.g.
+// {"id": {{.ID}}, "value": {{.Rand}}, "name": "user-{{.ID}}"}
+type templateRecord struct {
+	ID   int // record id offset by idstart
+	Rand int // random non-negative number, it is reproducible for seeded requests
+}
+
+// helper function to load record template from given file
+func loadRecordTemplate(fname string) (*template.Template, error) {
+	tmpl, err := template.ParseFiles(fname)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse record template %s, error %v", fname, err)
+	}
+	return tmpl, nil
+}
+
+// helper function to render record with given id by the template, the
+// template should render JSON object which becomes the record
+func templateRecordData(id int, opts genOptions) (Record, error) {
+	var buf strings.Builder
+	data := templateRecord{ID: opts.IDStart + id, Rand: int(opts.Rand.Int31())}
+	if err := opts.Template.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("unable to execute record template %s, error %v", opts.Template.Name(), err)
+	}
+	var rec Record
+	if err := json.Unmarshal([]byte(buf.String()), &rec); err != nil || rec == nil {
+		return nil, fmt.Errorf("record template %s should render JSON object, got %q, error %v", opts.Template.Name(), buf.String(), err)
+	}
+	return rec, nil
+}
+
+// helper function to generate single record with given sequence number, the
+// record either follows the schema, is rendered by the template or has default
+// {id, data} structure with id offset by opts.IDStart, all optionally followed
+// by timestamp field
+func genRecord(id int, opts genOptions) (Record, error) {
+	rec := make(Record)
+	if len(opts.Schema) > 0 {
+		// iterate over sorted fields to consume random source in stable order
+		var names []string
+		for name := range opts.Schema {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			rec[name] = schemaValue(opts.Rand, opts.Schema[name])
+		}
+		if opts.Timestamp != "" {
+			rec["timestamp"] = recordTimestamp(id, opts)
+		}
+		return rec, nil
+	}
+	if opts.Template != nil {
+		rec, err := templateRecordData(id, opts)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Timestamp != "" {
+			rec["timestamp"] = recordTimestamp(id, opts)
+		}
+		return rec, nil
+	}
+	data, err := recordData(opts)
+	if err != nil {
+		return nil, err
+	}
+	idField := "id"
+	if opts.IDField != "" {
+		idField = opts.IDField
+	}
+	rec[idField] = recordID(opts.IDStart+id, opts.IDType)
+	rec["data"] = nestData(data, opts.Depth)
+	if opts.Timestamp != "" {
+		rec["timestamp"] = recordTimestamp(id, opts)
+	}
+	return rec, nil
+}
+
+// helper function to validate timestamp options of the generator
+func validateTimestamp(o genOptions) error {
+	switch o.Timestamp {
+	case "rfc3339", "unix", "unixmilli":
+	default:
+		// layout without any reference time element is printed as is
+		if time.Unix(0, 0).UTC().Format(o.Timestamp) == o.Timestamp {
+			return fmt.Errorf("unsupported timestamp %q, should be rfc3339, unix, unixmilli or Go reference layout, e.g. 2006-01-02 15:04:05", o.Timestamp)
+		}
+	}
+	switch o.TimestampMode {
+	case "", "increment", "random":
+	default:
+		return fmt.Errorf("unsupported timestampmode %q, should be increment or random", o.TimestampMode)
+	}
+	if o.IDField == "timestamp" {
+		return errors.New("unsupported idfield \"timestamp\", it is reserved for timestamp field")
+	}
+	if _, ok := o.Schema["timestamp"]; ok {
+		return errors.New("unsupported schema field \"timestamp\", it is reserved for timestamp field")
+	}
+	return nil
+}
+
+// helper function to generate timestamp of record with given sequence number,
+// it either follows TimestampStart by a second per record or is random
+func recordTimestamp(id int, opts genOptions) interface{} {
+	ts := opts.TimestampStart.Add(time.Duration(id) * time.Second)
+	if opts.TimestampMode == "random" {
+		ts = time.Unix(minTimestamp+opts.Rand.Int63n(maxTimestamp-minTimestamp), 0).UTC()
+	}
+	switch opts.Timestamp {
+	case "rfc3339":
+		return ts.Format(time.RFC3339)
+	case "unix":
+		return ts.Unix()
+	case "unixmilli":
+		return ts.UnixMilli()
+	}
+	return ts.Format(opts.Timestamp)
+}
+
+// recordGenerator lazily produces series of records either for given number
+// of rows or until records totaling in size to target number of bytes are produced
+type recordGenerator struct {
+	opts   genOptions
+	count  int   // number of records, negative means generate up to target size
+	target int64 // target size in bytes measured as length of JSON array of records
+	id     int   // id of next record which is also number of produced records
+	total  int64 // size of produced records
+
+	// state of parallel generation used when opts.Workers > 1
+	pending chan chan genChunk // chunks in order of their records
+	chunk   genChunk           // remaining records of current chunk
+	done    chan struct{}      // closed to stop parallel generation
+}
+
+// genChunk represents chunk of records produced by generation worker
+// along with JSON sizes of records used to account for target size
+type genChunk struct {
+	records []Record
+	sizes   []int
+	err     error
+}
+
+// genJob represents job of generation worker
+type genJob struct {
+	start int   // id of the first record of the chunk
+	size  int   // number of records in the chunk
+	seed  int64 // seed of random source of the chunk
+	out   chan genChunk
+}
+
+// chunkRecords defines number of records generated by worker in one job
+const chunkRecords = 256
+
+// helper function to create record generator for given number of rows,
+// negative count means records are generated up to given target size
+func newRecordGenerator(count int, target int64, opts genOptions) *recordGenerator {
+	return &recordGenerator{
+		opts:   opts,
+		count:  count,
+		target: target,
+		total:  int64(len("[]")),
+	}
+}
+
+// helper function to produce next record, it returns false when all records are produced
+func (g *recordGenerator) next() (Record, bool, error) {
+	if g.count >= 0 && g.id >= g.count {
+		return nil, false, nil
+	}
+	if g.count < 0 && g.total >= g.target {
+		return nil, false, nil
+	}
+	var rec Record
+	var size int
+	var err error
+	if g.opts.Workers > 1 {
+		rec, size, err = g.nextParallel()
+	} else {
+		rec, size, err = genSizedRecord(g.id, g.opts, g.count < 0)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if g.count < 0 {
+		// account for comma separator between records
+		g.total += int64(size)
+		if g.id > 0 {
+			g.total++
+		}
+	}
+	g.id++
+	return rec, true, nil
+}
+
+// helper function to generate record along with its JSON size if requested
+func genSizedRecord(id int, opts genOptions, sized bool) (Record, int, error) {
+	rec, err := genRecord(id, opts)
+	if err != nil || !sized {
+		return rec, 0, err
+	}
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rec, len(raw), nil
+}
+
+// helper function to take next record produced by generation workers
+func (g *recordGenerator) nextParallel() (Record, int, error) {
+	if len(g.chunk.records) == 0 {
+		if g.pending == nil {
+			g.start()
+		}
+		g.chunk = <-<-g.pending
+		if g.chunk.err != nil {
+			return nil, 0, g.chunk.err
+		}
+	}
+	rec, size := g.chunk.records[0], g.chunk.sizes[0]
+	g.chunk.records, g.chunk.sizes = g.chunk.records[1:], g.chunk.sizes[1:]
+	return rec, size, nil
+}
+
+// helper function to start generation workers, the id space is split into
+// chunks of chunkRecords records and every chunk is generated with its own
+// random source seeded from generator random source in order of chunks, so
+// seeded output is reproducible regardless of number of workers and their
+// scheduling, though it differs from output of sequential generation
+func (g *recordGenerator) start() {
+	workers := g.opts.Workers
+	jobs := make(chan genJob)
+	g.pending = make(chan chan genChunk, workers)
+	g.done = make(chan struct{})
+	for i := 0; i < workers; i++ {
+		go genWorker(jobs, g.opts, g.count < 0)
+	}
+	// copy fields used by dispatcher since generator is owned by consumer
+	count, rnd, done, pending := g.count, g.opts.Rand, g.done, g.pending
+	go func() {
+		defer close(jobs)
+		for start := 0; count < 0 || start < count; start += chunkRecords {
+			size := chunkRecords
+			if count >= 0 && count-start < size {
+				size = count - start
+			}
+			job := genJob{start: start, size: size, seed: rnd.Int63(), out: make(chan genChunk, 1)}
+			select {
+			case pending <- job.out:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- job:
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// helper function to generate chunks of records for given jobs
+func genWorker(jobs <-chan genJob, opts genOptions, sized bool) {
+	for job := range jobs {
+		opts.Rand = rand.New(rand.NewSource(job.seed))
+		chunk := genChunk{
+			records: make([]Record, 0, job.size),
+			sizes:   make([]int, 0, job.size),
+		}
+		for id := job.start; id < job.start+job.size; id++ {
+			rec, size, err := genSizedRecord(id, opts, sized)
+			if err != nil {
+				chunk = genChunk{err: err}
+				break
+			}
+			chunk.records = append(chunk.records, rec)
+			chunk.sizes = append(chunk.sizes, size)
+		}
+		job.out <- chunk
+	}
+}
+
+// helper function to stop generation workers, it should be called once
+// records are no longer needed
+func (g *recordGenerator) close() {
+	if g.done != nil {
+		close(g.done)
+		g.done = nil
+	}
+}
+
+// helper function to collect all remaining records of the generator
+func (g *recordGenerator) all() ([]Record, error) {
+	defer g.close()
+	records := []Record{}
+	if g.count > 0 {
+		records = make([]Record, 0, g.count)
+	}
+	for {
+		rec, ok, err := g.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return records, nil
+		}
+		records = append(records, rec)
+	}
+}
+
+// helper function to convert record id into given type, e.g.
+// id 1 is represented as 1, "1" or 1.0 for int, string and float types
+func recordID(id int, typ string) interface{} {
+	switch typ {
+	case "string":
+		return strconv.Itoa(id)
+	case "float":
+		return float64(id)
+	}
+	return id
+}
+
+// helper function to nest data into given number of levels of child objects,
+// e.g. depth=2 produces {"child":{"value":data}}, zero depth keeps data as is
+func nestData(data []byte, depth int) interface{} {
+	if depth <= 0 {
+		return data
+	}
+	node := Record{"value": data}
+	for i := 1; i < depth; i++ {
+		node = Record{"child": node}
+	}
+	return node
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// helper function to generate records with given number of workers
+func testRecords(t *testing.T, count int, target int64, workers int) []Record {
+	opts := genOptions{Rand: rand.New(rand.NewSource(1)), DataSize: 16, Workers: workers}
+	records, err := newRecordGenerator(count, target, opts).all()
+	if err != nil {
+		t.Fatalf("unable to generate records with %d workers, error %v", workers, err)
+	}
+	return records
+}
+
+// TestParallelGenerator tests that parallel generation produces records in
+// order and its seeded output does not depend on number of workers
+func TestParallelGenerator(t *testing.T) {
+	count := 3*chunkRecords + 7
+	records := testRecords(t, count, 0, 2)
+	if len(records) != count {
+		t.Fatalf("generated %d records, expected %d", len(records), count)
+	}
+	for i, rec := range records {
+		if rec["id"] != i {
+			t.Fatalf("record %d has id %v", i, rec["id"])
+		}
+	}
+	for _, workers := range []int{3, 8} {
+		if !reflect.DeepEqual(records, testRecords(t, count, 0, workers)) {
+			t.Errorf("records generated with %d workers differ from ones of 2 workers", workers)
+		}
+	}
+}
+
+// TestParallelGeneratorSize tests that parallel generation stops once
+// records reach target size
+func TestParallelGeneratorSize(t *testing.T) {
+	target := int64(100 * 1000)
+	for _, workers := range []int{1, 4} {
+		records := testRecords(t, -1, target, workers)
+		data, err := json.Marshal(records)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last, err := json.Marshal(records[len(records)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := int64(len(data)); size < target || size > target+int64(len(last))+1 {
+			t.Errorf("records generated with %d workers have size %d, expected about %d", workers, size, target)
+		}
+	}
+}
+
+// TestParseSize tests parsing of size strings into number of bytes
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size  string
+		bytes int64
+		valid bool
+	}{
+		{"5MB", 5 * 1000 * 1000, true},
+		{" 5 mb", 5 * 1000 * 1000, true},
+		{"1.5MiB", 1024 * 1024 * 3 / 2, true},
+		{"10kib", 10 * 1024, true},
+		{"100B", 100, true},
+		{"2GB", 2 * 1000 * 1000 * 1000, true},
+		{"5", 0, false},
+		{"5XB", 0, false},
+		{"-1KB", 0, false},
+		{"MB", 0, false},
+		{"1e30GB", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		bytes, err := parseSize(tt.size)
+		if (err == nil) != tt.valid {
+			t.Errorf("parseSize(%q) error %v, expected valid=%v", tt.size, err, tt.valid)
+		} else if bytes != tt.bytes {
+			t.Errorf("parseSize(%q) = %d, expected %d", tt.size, bytes, tt.bytes)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// helper function to listen with SO_REUSEPORT socket option, it is
+// supported only on Linux
+func listenReusePort(addr string) (net.Listener, error) {
+	return nil, fmt.Errorf("reuseport is supported only on linux, got %s", runtime.GOOS)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats represents lightweight server statistics exposed as JSON, counters
+// are updated atomically to stay lock-free on the hot path
+type Stats struct {
+	requests atomic.Uint64 // total number of requests
+	bytes    atomic.Uint64 // total number of response bytes
+	errors   atomic.Uint64 // total number of responses with 4xx and 5xx status codes
+	paths    sync.Map      // number of requests per path, path -> *atomic.Uint64
+	statuses sync.Map      // responses per path by status class, path -> *statusClasses
+	nstatus  atomic.Int64  // number of paths in statuses
+}
+
+// statusClassNames defines status classes counted by Stats
+var statusClassNames = []string{"2xx", "3xx", "4xx", "5xx"}
+
+// statusClasses represents counters of responses by status class
+type statusClasses [4]atomic.Uint64
+
+// maxStatusPaths defines max number of distinct paths of status class counters,
+// responses of other paths are counted under otherPath to bound memory usage
+// since arbitrary paths are served by RequestHandler
+const maxStatusPaths = 1000
+
+// otherPath defines path of status class counters of the remaining paths
+const otherPath = "other"
+
+// StatsSnapshot represents point in time view of server statistics
+type StatsSnapshot struct {
+	Requests uint64                       `json:"requests"`
+	Bytes    uint64                       `json:"bytes"`
+	Errors   uint64                       `json:"errors"`
+	Paths    map[string]uint64            `json:"paths"`
+	Statuses map[string]map[string]uint64 `json:"statuses"`
+}
+
+// stats holds server statistics
+var stats Stats
+
+// helper function to record request of given path with its response size and status
+func (s *Stats) record(path string, bytes int64, status int) {
+	s.requests.Add(1)
+	s.bytes.Add(uint64(bytes))
+	if status >= 400 {
+		s.errors.Add(1)
+	}
+	counter, ok := s.paths.Load(path)
+	if !ok {
+		counter, _ = s.paths.LoadOrStore(path, new(atomic.Uint64))
+	}
+	counter.(*atomic.Uint64).Add(1)
+}
+
+// helper function to record response status of given path by its class,
+// informational 1xx responses are not counted
+func (s *Stats) recordStatus(path string, status int) {
+	idx := status/100 - 2
+	if idx < 0 || idx >= len(statusClassNames) {
+		return
+	}
+	counters, ok := s.statuses.Load(path)
+	if !ok {
+		if s.nstatus.Load() >= maxStatusPaths {
+			path = otherPath
+		}
+		var loaded bool
+		counters, loaded = s.statuses.LoadOrStore(path, new(statusClasses))
+		if !loaded {
+			s.nstatus.Add(1)
+		}
+	}
+	counters.(*statusClasses)[idx].Add(1)
+}
+
+// helper function to take snapshot of statistics, with reset option
+// counters are zeroed while being read
+func (s *Stats) snapshot(reset bool) StatsSnapshot {
+	load := func(c *atomic.Uint64) uint64 {
+		if reset {
+			return c.Swap(0)
+		}
+		return c.Load()
+	}
+	snap := StatsSnapshot{
+		Requests: load(&s.requests),
+		Bytes:    load(&s.bytes),
+		Errors:   load(&s.errors),
+		Paths:    make(map[string]uint64),
+		Statuses: make(map[string]map[string]uint64),
+	}
+	s.paths.Range(func(key, value interface{}) bool {
+		snap.Paths[key.(string)] = load(value.(*atomic.Uint64))
+		return true
+	})
+	s.statuses.Range(func(key, value interface{}) bool {
+		classes := make(map[string]uint64)
+		for i, name := range statusClassNames {
+			classes[name] = load(&value.(*statusClasses)[i])
+		}
+		snap.Statuses[key.(string)] = classes
+		return true
+	})
+	return snap
+}
+
+// StatsHandler provides server statistics as JSON, e.g.
+// {"requests":10,"bytes":1024,"errors":1,"paths":{"/payload":9,"/":1},
+// "statuses":{"/payload":{"2xx":8,"3xx":0,"4xx":0,"5xx":1},...}}
+// with reset=true parameter counters are reset after being read
+func StatsHandler(w http.ResponseWriter, r *http.Request) {
+	var reset bool
+	if val := r.URL.Query().Get("reset"); val != "" {
+		v, err := strconv.ParseBool(val)
+		if err != nil {
+			msg := fmt.Sprintf("invalid reset value %q, should be true or false", val)
+			HTTPError("ERROR", msg, http.StatusBadRequest, w, r)
+			return
+		}
+		reset = v
+	}
+	data, err := json.Marshal(stats.snapshot(reset))
+	if err != nil {
+		msg := fmt.Sprintf("unable to marshal stats, error %v", err)
+		HTTPError("ERROR", msg, http.StatusInternalServerError, w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
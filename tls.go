@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsVersions defines mapping of TLS version names to their tls constants
+var tlsVersions = map[string]uint16{
+	"TLS1.0": tls.VersionTLS10,
+	"TLS1.1": tls.VersionTLS11,
+	"TLS1.2": tls.VersionTLS12,
+	"TLS1.3": tls.VersionTLS13,
+}
+
+// helper function to convert TLS version name into tls constant,
+// empty name defaults to TLS1.2
+func tlsVersion(name string) (uint16, error) {
+	if name == "" {
+		return tls.VersionTLS12, nil
+	}
+	if v, ok := tlsVersions[name]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, should be TLS1.0, TLS1.1, TLS1.2 or TLS1.3", name)
+}
+
+// helper function to convert cipher suite names, e.g.
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, into their ids
+func cipherSuites(names []string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		suites[s.Name] = s.ID
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		suites[s.Name] = s.ID
+	}
+	var ids []uint16
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// clientAuthModes defines mapping of client authentication mode names to tls constants
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"none":             tls.NoClientCert,
+	"request":          tls.RequestClientCert,
+	"require":          tls.RequireAnyClientCert,
+	"verifyifgiven":    tls.VerifyClientCertIfGiven,
+	"requireandverify": tls.RequireAndVerifyClientCert,
+}
+
+// helper function to convert client authentication mode name into tls constant,
+// empty name defaults to requireandverify when client CA is configured
+func clientAuthMode(name, clientCA string) (tls.ClientAuthType, error) {
+	if name == "" {
+		if clientCA != "" {
+			return tls.RequireAndVerifyClientCert, nil
+		}
+		return tls.NoClientCert, nil
+	}
+	if v, ok := clientAuthModes[name]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unsupported client auth mode %q, should be none, request, require, verifyifgiven or requireandverify", name)
+}
+
+// helper function to load pool of CA certificates from given PEM file
+func loadCertPool(fname string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA file %s, error %v", fname, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no valid certificates found in CA file %s", fname)
+	}
+	return pool, nil
+}
+
+// certReloader provides server certificate loaded from disk, the certificate
+// is reloaded when modification time of its files changes, so rotated
+// certificates are picked up without server restart
+type certReloader struct {
+	mu       sync.Mutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	certMod  time.Time
+	keyMod   time.Time
+}
+
+// helper function to create certificate reloader for given files,
+// the certificate is loaded immediately to report errors on startup
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// helper function to load certificate if its files were modified since last load
+func (c *certReloader) reload() error {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return fmt.Errorf("unable to stat certificate file %s, error %v", c.certFile, err)
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to stat key file %s, error %v", c.keyFile, err)
+	}
+	if c.cert != nil && certInfo.ModTime().Equal(c.certMod) && keyInfo.ModTime().Equal(c.keyMod) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load certificate %s and key %s, error %v", c.certFile, c.keyFile, err)
+	}
+	if c.cert != nil {
+		logMessage("INFO", fmt.Sprintf("reloaded server certificate %s", c.certFile), nil, 0)
+	}
+	c.cert = &cert
+	c.certMod = certInfo.ModTime()
+	c.keyMod = keyInfo.ModTime()
+	return nil
+}
+
+// getCertificate implements tls.Config.GetCertificate callback, if rotated
+// certificate can't be loaded, e.g. files are partially written, the previous
+// certificate is served and reload is retried on next handshake
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.reload(); err != nil {
+		logMessage("ERROR", err.Error(), nil, 0)
+	}
+	return c.cert, nil
+}
+
+// helper function to create TLS configuration of the server, cipher suites
+// are only applied to TLS1.2 and earlier since TLS1.3 suites are not configurable
+func serverTLSConfig() (*tls.Config, error) {
+	minVersion, err := tlsVersion(Config.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	ciphers, err := cipherSuites(Config.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, err := clientAuthMode(Config.ClientAuthMode, Config.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	reloader, err := newCertReloader(Config.ServerCrt, Config.ServerKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   ciphers,
+		ClientAuth:     clientAuth,
+		GetCertificate: reloader.getCertificate,
+	}
+	if Config.ClientCA != "" {
+		pool, err := loadCertPool(Config.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+	}
+	return tlsConfig, nil
+}